@@ -0,0 +1,133 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/trace"
+)
+
+// root returns absolute path of the project without symlinks
+func (e *Executor) root() (string, error) {
+	root, err := filepath.Abs(e.path("."))
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(root)
+}
+
+// relPaths converts absolute paths of the project files to slash separated
+// relative ones, files outside of the project and in the state dir are dropped
+func (e *Executor) relPaths(paths []string) ([]string, error) {
+	root, err := e.root()
+	if err != nil {
+		return nil, err
+	}
+	rel := []string{}
+	for _, path := range paths {
+		p, err := filepath.Rel(root, path)
+		if err != nil || p == "." || strings.HasPrefix(p, "..") {
+			continue
+		}
+		p = filepath.ToSlash(p)
+		if p == state.Dir || strings.HasPrefix(p, state.Dir+"/") {
+			continue
+		}
+		rel = append(rel, p)
+	}
+	return rel, nil
+}
+
+// generated reports whether the file is an output of some rule
+func (e *Executor) generated(path string) bool {
+	if e.Graph != nil {
+		if f := e.Graph.File(path); f != nil && f.Generated() {
+			return true
+		}
+	}
+	if e.State == nil {
+		return false
+	}
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	return e.State.Record(path) != nil
+}
+
+// checkAccesses returns source files read by the command which are not declared
+// as inputs, they become implicit dependencies of the command. Undeclared
+// generated files are an error, because the order of the commands is unknown.
+// Writes are allowed only to the outputs and temporary files.
+func (e *Executor) checkAccesses(node *graph.CommandNode, accesses *trace.Accesses) ([]string, error) {
+	reads, err := e.relPaths(accesses.Reads)
+	if err != nil {
+		return nil, err
+	}
+	writes, err := e.relPaths(accesses.Writes)
+	if err != nil {
+		return nil, err
+	}
+	removes, err := e.relPaths(accesses.Removes)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkWrites(node, writes, removes); err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, paths := range [][]string{node.Command.Inputs, node.Command.Outputs, writes} {
+		for _, path := range paths {
+			known[path] = true
+		}
+	}
+
+	implicit := []string{}
+	for _, path := range reads {
+		if known[path] {
+			continue
+		}
+		if info, err := os.Stat(e.path(path)); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if e.generated(path) {
+			return nil, fmt.Errorf("command read %s which is not a declared input", path)
+		}
+		implicit = append(implicit, path)
+	}
+	return implicit, nil
+}
+
+// checkWrites fails if the command left files which are not its outputs
+// or removed files which it didn't create
+func (e *Executor) checkWrites(node *graph.CommandNode, writes, removes []string) error {
+	outputs := map[string]bool{}
+	for _, output := range node.Command.Outputs {
+		outputs[output] = true
+	}
+	written := map[string]bool{}
+	problems := []string{}
+	for _, path := range writes {
+		written[path] = true
+		if _, err := os.Lstat(e.path(path)); err == nil && !outputs[path] {
+			problems = append(problems, fmt.Sprintf("command wrote %s which is not a declared output", path))
+		}
+	}
+	for _, path := range removes {
+		if written[path] || outputs[path] {
+			continue
+		}
+		if _, err := os.Lstat(e.path(path)); os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("command removed %s which is not a declared output", path))
+		}
+	}
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", problems[0])
+	}
+	return fmt.Errorf("%s (and %d more)", problems[0], len(problems)-1)
+}
@@ -0,0 +1,95 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/trace"
+)
+
+func TestExecutorTrace(t *testing.T) {
+	if !trace.Supported() {
+		t.Skip(trace.ErrUnsupported)
+	}
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "gen.txt": "gen", "config.txt": "config"})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: gen.txt |> cp %f %o |> gen.out
+: a.txt |> cat %f config.txt > %o |> with-config.out
+: a.txt |> cat %f gen.out > %o |> bad.out
+: a.txt |> cp %f %o && ln -s %o symlink.txt |> symlinked.out
+: a.txt |> cp %f %o && ln %o hardlink.txt |> linked.out
+: a.txt |> cp %f %o && mkdir cache |> mkdir.out
+`)
+	e := NewExecutor()
+	e.Jobs = 1
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.KeepGoing = true
+	e.Graph = g
+	e.State = state.New(filepath.Join(dir, state.Dir, state.FileName))
+
+	result := e.Run(g.Sort())
+	if err := result.Commands[2].Err; err == nil || err.Error() != "command read gen.out which is not a declared input" {
+		t.Errorf("expected undeclared input error, got %v", err)
+	}
+	if implicit := result.Commands[1].Implicit; !reflect.DeepEqual(implicit, []string{"config.txt"}) {
+		t.Errorf("expected implicit dependency on config.txt, got %v", implicit)
+	}
+	for i, path := range []string{"symlink.txt", "hardlink.txt", "cache"} {
+		expected := fmt.Sprintf("command wrote %s which is not a declared output", path)
+		if err := result.Commands[3+i].Err; err == nil || err.Error() != expected {
+			t.Errorf("expected error '%s', got %v", expected, err)
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "config.txt"), []byte("new config"), 0644)
+	result = e.Run(g.Sort()[:2])
+	r := result.Commands[1]
+	if r.Status != StatusOk || r.Reason != "config.txt changed" {
+		t.Errorf("expected rebuild because of config.txt, got %v: %s", r.Status, r.Reason)
+	}
+	if content := readFile(t, dir, "with-config.out"); content != "anew config" {
+		t.Errorf("unexpected content %q", content)
+	}
+}
+
+func TestExecutorWrites(t *testing.T) {
+	if !trace.Supported() {
+		t.Skip(trace.ErrUnsupported)
+	}
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: a.txt |> cp %f tmp.txt && mv tmp.txt %o |> via-tmp.out
+: a.txt |> cp %f %o && echo cache > src-cache.txt |> leaky.out
+: a.txt |> cp %f %o && rm b.txt |> remover.out
+`)
+	e := NewExecutor()
+	e.Jobs = 1
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.KeepGoing = true
+	e.Graph = g
+
+	expected := []string{
+		"",
+		"command wrote src-cache.txt which is not a declared output",
+		"command removed b.txt which is not a declared output",
+	}
+	for i, r := range e.Run(g.Sort()).Commands {
+		err := ""
+		if r.Err != nil {
+			err = r.Err.Error()
+		}
+		if err != expected[i] {
+			t.Errorf("[%s] expected error %q, got %q", r.Node.Command.Cmd, expected[i], err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anru/vake/graph"
+)
+
+type difference struct {
+	output string
+	text   string
+}
+
+// Diff compares commands of the graph with the state of the last build
+// and returns differences sorted by outputs, nothing is run
+func (e *Executor) Diff(g *graph.Graph) []string {
+	diffs := []difference{}
+	add := func(output, format string, a ...interface{}) {
+		diffs = append(diffs, difference{output, output + ": " + fmt.Sprintf(format, a...)})
+	}
+	for _, c := range g.Commands {
+		outputs := c.Command.Outputs
+		if len(outputs) == 0 {
+			continue
+		}
+		r := e.State.Record(outputs[0])
+		if r == nil {
+			add(outputs[0], "new output of %s", c.Command.Rule.Pos)
+			continue
+		}
+		if recorded := r.OutputPaths(); strings.Join(recorded, " ") != strings.Join(sorted(outputs), " ") {
+			add(outputs[0], "outputs changed\n\twas: %s\n\tnow: %s", strings.Join(recorded, " "), strings.Join(sorted(outputs), " "))
+		}
+		if r.Command != c.Command.Cmd {
+			add(outputs[0], "command changed\n\twas: %s\n\tnow: %s", r.Command, c.Command.Cmd)
+		}
+		added, removed := []string{}, []string{}
+		inputs := map[string]bool{}
+		for _, input := range c.Command.Inputs {
+			inputs[input] = true
+			if _, ok := r.Inputs[input]; !ok {
+				added = append(added, input)
+			}
+		}
+		for input := range r.Inputs {
+			if !inputs[input] {
+				removed = append(removed, input)
+			}
+		}
+		if len(added) != 0 || len(removed) != 0 {
+			text := "inputs changed"
+			if len(added) != 0 {
+				text += "\n\tadded: " + strings.Join(sorted(added), " ")
+			}
+			if len(removed) != 0 {
+				text += "\n\tremoved: " + strings.Join(sorted(removed), " ")
+			}
+			add(outputs[0], "%s", text)
+		}
+	}
+	for _, output := range e.State.Outputs() {
+		if e.State.Record(output).Vakefile != e.Vakefile {
+			continue
+		}
+		if f := g.File(output); f == nil || !f.Generated() {
+			add(output, "is not produced anymore")
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].output < diffs[j].output
+	})
+	out := make([]string, 0, len(diffs))
+	for _, d := range diffs {
+		out = append(out, d.text)
+	}
+	return out
+}
+
+func sorted(paths []string) []string {
+	out := append([]string{}, paths...)
+	sort.Strings(out)
+	return out
+}
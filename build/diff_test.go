@@ -0,0 +1,60 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anru/vake/state"
+)
+
+func TestDiff(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"src/a.css": "a", "src/b.css": "b", "src/c.js": "c"})
+	defer os.RemoveAll(dir)
+	e := NewExecutor()
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.State = state.New(filepath.Join(dir, state.Dir, state.FileName))
+	g := newTestGraph(t, dir, `
+: foreach src/*.css |> cp %f %o |> build/%B.css
+: src/*.js |> cat %f > %o |> app.js
+`)
+	if err := e.Run(g.Sort()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	refactored := newTestGraph(t, dir, `
+!copy = foreach |> cp %f %o |>
+!bundle = |> cat %f > %o |>
+: src/*.css |> !copy |> build/%B.css
+: src/*.js |> !bundle |> app.js
+`)
+	if diffs := e.Diff(refactored); len(diffs) != 0 {
+		t.Errorf("expected no differences, got %v", diffs)
+	}
+
+	changed := newTestGraph(t, dir, `
+: foreach src/*.css |> cp -p %f %o |> build/%B.css
+: src/*.css src/*.js |> cat %f > %o |> static/app.js
+`)
+	expected := []string{
+		"app.js: is not produced anymore",
+		"build/a.css: command changed\n\twas: cp src/a.css build/a.css\n\tnow: cp -p src/a.css build/a.css",
+		"build/b.css: command changed\n\twas: cp src/b.css build/b.css\n\tnow: cp -p src/b.css build/b.css",
+		"static/app.js: new output of Vakefile:3",
+	}
+	if diffs := e.Diff(changed); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected differences %q, got %q", expected, diffs)
+	}
+
+	inputs := newTestGraph(t, dir, `
+: foreach src/*.css |> cp %f %o |> build/%B.css
+: src/*.css src/*.js |> cat src/c.js > %o |> app.js
+`)
+	expected = []string{"app.js: inputs changed\n\tadded: src/a.css src/b.css"}
+	if diffs := e.Diff(inputs); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected differences %q, got %q", expected, diffs)
+	}
+}
@@ -0,0 +1,344 @@
+// Package build runs commands of the dependency graph
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/trace"
+)
+
+// DefaultShell runs rule commands
+var DefaultShell = []string{"sh", "-c"}
+
+type Status int
+
+const (
+	StatusPending Status = iota
+	StatusOk
+	StatusFailed
+	// command was not run because of failure of other command
+	StatusSkipped
+	// command was not run because its outputs are up to date
+	StatusUpToDate
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOk:
+		return "ok"
+	case StatusFailed:
+		return "failed"
+	case StatusSkipped:
+		return "skipped"
+	case StatusUpToDate:
+		return "up to date"
+	}
+	return "pending"
+}
+
+type CommandResult struct {
+	Node   *graph.CommandNode
+	Status Status
+	Reason string // why the command was run
+	// Identical is set when rebuilt outputs are the same as before,
+	// so commands depending on them are not affected
+	Identical bool
+	// Implicit are undeclared source files read by the traced command
+	Implicit []string
+	Err      error
+	Output   []byte // combined stdout and stderr
+	Duration time.Duration
+}
+
+type Result struct {
+	// results in order of given commands
+	Commands []*CommandResult
+}
+
+// Failed returns results of failed commands
+func (r *Result) Failed() []*CommandResult {
+	out := []*CommandResult{}
+	for _, c := range r.Commands {
+		if c.Status == StatusFailed {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Count returns number of commands with given status
+func (r *Result) Count(status Status) int {
+	n := 0
+	for _, c := range r.Commands {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Err summarizes failures, nil if build succeeded
+func (r *Result) Err() error {
+	failed := r.Failed()
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("build failed: %s: %v", failed[0].Node.Command.Rule.Pos, failed[0].Err)
+	}
+	return fmt.Errorf("build failed: %d commands failed, the first one at %s: %v",
+		len(failed), failed[0].Node.Command.Rule.Pos, failed[0].Err)
+}
+
+type Executor struct {
+	// Jobs is the number of parallel workers
+	Jobs int
+	// Shell is a command line, the rule command is passed as the last argument
+	Shell []string
+	Dir   string
+	// KeepGoing continues building of commands which don't depend on failed ones
+	KeepGoing bool
+	// Output receives output of the commands, output of one running command
+	// is streamed, output of others waits until it finishes
+	Output io.Writer
+	// State records successfully built outputs when set
+	State *state.State
+	// Vakefile is recorded with the outputs, the state is shared
+	// by all vakefiles of the project
+	Vakefile string
+	// Trace checks files accessed by the commands, see checkAccesses
+	Trace bool
+	// Graph is used to find generated files read by the traced commands
+	Graph *graph.Graph
+	// Sandbox runs commands in namespaces with inputs and directories
+	// of outputs only, traced checks still catch accesses to the files
+	// next to the outputs, when the whole project is writable for example
+	Sandbox bool
+
+	outputMu sync.Mutex
+	// outputs of running commands in the order of start, see commandOutput
+	outputs []*commandOutput
+	stateMu sync.Mutex
+}
+
+// NewExecutor creates executor with a worker per CPU
+func NewExecutor() *Executor {
+	return &Executor{
+		Jobs:   runtime.NumCPU(),
+		Shell:  DefaultShell,
+		Output: os.Stdout,
+		Trace:  trace.Supported(),
+	}
+}
+
+// Run runs commands respecting dependencies between them,
+// dependencies out of the given list are considered to be built
+func (e *Executor) Run(commands []*graph.CommandNode) *Result {
+	result := &Result{}
+	results := map[*graph.CommandNode]*CommandResult{}
+	waiting := map[*graph.CommandNode]int{}
+	dependents := map[*graph.CommandNode][]*graph.CommandNode{}
+	for _, c := range commands {
+		r := &CommandResult{Node: c}
+		result.Commands = append(result.Commands, r)
+		results[c] = r
+	}
+	if e.State != nil {
+		e.detectChanges(commands)
+	}
+	ready := []*graph.CommandNode{}
+	for _, c := range commands {
+		for _, dep := range c.Deps() {
+			if _, ok := results[dep]; ok {
+				waiting[c]++
+				dependents[dep] = append(dependents[dep], c)
+			}
+		}
+		if waiting[c] == 0 {
+			ready = append(ready, c)
+		}
+	}
+
+	jobs := e.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	done := make(chan *CommandResult)
+	running := 0
+	stopped := false
+	for {
+		for len(ready) != 0 && running < jobs && !stopped {
+			c := ready[0]
+			ready = ready[1:]
+			running++
+			go func(r *CommandResult) {
+				e.runCommand(r)
+				done <- r
+			}(results[c])
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-done
+		running--
+		if r.Status == StatusFailed {
+			stopped = !e.KeepGoing
+			continue
+		}
+		for _, dependent := range dependents[r.Node] {
+			if waiting[dependent]--; waiting[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool {
+			return ready[i].ID < ready[j].ID
+		})
+	}
+
+	for _, r := range result.Commands {
+		if r.Status == StatusPending {
+			r.Status = StatusSkipped
+		}
+	}
+	return result
+}
+
+func (e *Executor) shell() []string {
+	if len(e.Shell) == 0 {
+		return DefaultShell
+	}
+	return e.Shell
+}
+
+func (e *Executor) path(file string) string {
+	return filepath.Join(e.Dir, filepath.FromSlash(file))
+}
+
+func (e *Executor) runCommand(r *CommandResult) {
+	start := time.Now()
+	r.Reason = e.outdated(r.Node.Command)
+	if len(r.Reason) == 0 {
+		r.Status = StatusUpToDate
+		return
+	}
+	out := e.startOutput()
+	defer out.finish()
+	fmt.Fprintln(out, r.Node.Command.Cmd)
+	r.Err = e.execute(r, out)
+	if r.Err == nil && e.State != nil {
+		r.Identical, r.Err = e.record(r.Node, r.Implicit)
+	}
+	r.Duration = time.Since(start)
+	r.Status = StatusOk
+	if r.Err != nil {
+		r.Status = StatusFailed
+		// partial outputs must not look like built ones
+		for _, output := range r.Node.Command.Outputs {
+			os.Remove(e.path(output))
+		}
+		fmt.Fprintf(out, "%s: %v\n", r.Node.Command.Rule.Pos, r.Err)
+	}
+}
+
+// commandOutput is written to the executor output while its command
+// is the first of running ones, otherwise it's buffered
+type commandOutput struct {
+	e    *Executor
+	buf  bytes.Buffer
+	done bool
+}
+
+func (e *Executor) startOutput() *commandOutput {
+	e.outputMu.Lock()
+	defer e.outputMu.Unlock()
+	o := &commandOutput{e: e}
+	e.outputs = append(e.outputs, o)
+	return o
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.e.outputMu.Lock()
+	defer o.e.outputMu.Unlock()
+	if o.e.outputs[0] == o {
+		return o.e.Output.Write(p)
+	}
+	return o.buf.Write(p)
+}
+
+// finish writes buffered output of the next commands,
+// the first of them still running streams its output then
+func (o *commandOutput) finish() {
+	e := o.e
+	e.outputMu.Lock()
+	defer e.outputMu.Unlock()
+	o.done = true
+	for len(e.outputs) != 0 {
+		first := e.outputs[0]
+		e.Output.Write(first.buf.Bytes())
+		first.buf.Reset()
+		if !first.done {
+			break
+		}
+		e.outputs = e.outputs[1:]
+	}
+}
+
+func (e *Executor) execute(r *CommandResult, stream io.Writer) error {
+	c := r.Node.Command
+	for _, output := range c.Outputs {
+		if err := os.MkdirAll(filepath.Dir(e.path(output)), 0755); err != nil {
+			return err
+		}
+	}
+
+	args := append(append([]string{}, e.shell()...), c.Cmd)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = e.Dir
+	if e.Sandbox {
+		var err error
+		var release func()
+		if cmd, release, err = e.sandboxCommand(c, args); err != nil {
+			return err
+		}
+		defer release()
+	}
+	var out bytes.Buffer
+	w := io.MultiWriter(&out, stream)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	var err error
+	var accesses *trace.Accesses
+	if e.Trace {
+		accesses, err = trace.Run(cmd)
+	} else {
+		err = cmd.Run()
+	}
+	r.Output = out.Bytes()
+	if err != nil {
+		return err
+	}
+	if accesses != nil {
+		if r.Implicit, err = e.checkAccesses(r.Node, accesses); err != nil {
+			return err
+		}
+	}
+
+	for _, output := range c.Outputs {
+		if _, err := os.Stat(e.path(output)); err != nil {
+			return fmt.Errorf("command did not produce %s", output)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anru/vake/glob"
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/vakefile"
+)
+
+// newTestProject creates project in temporary directory
+func newTestProject(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "vake-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newTestGraph(t *testing.T, dir, source string) *graph.Graph {
+	nodes, err := vakefile.Parse("Vakefile", source, &vakefile.ParserEnv{}).Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := glob.Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, err := vakefile.ExpandRules(nodes, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := graph.New(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func readFile(t *testing.T, dir, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestExecutor(t *testing.T) {
+	dir := newTestProject(t, map[string]string{
+		"src/a.css": "a\n",
+		"src/b.css": "b\n",
+	})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: foreach src/*.css |> tr a-z A-Z < %f > %o |> build/%B.css
+: build/*.css |> cat %f > %o && echo bundled |> static/bundle.css
+`)
+
+	out := &bytes.Buffer{}
+	e := NewExecutor()
+	e.Jobs = 2
+	e.Dir = dir
+	e.Output = out
+	e.State = state.New(filepath.Join(dir, state.Dir, state.FileName))
+	result := e.Run(g.Sort())
+	if err := result.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if result.Count(StatusOk) != 3 {
+		t.Errorf("expected 3 successful commands, got %d", result.Count(StatusOk))
+	}
+	if content := readFile(t, dir, "static/bundle.css"); content != "A\nB\n" {
+		t.Errorf("unexpected bundle content %q", content)
+	}
+	r := e.State.Record("static/bundle.css")
+	if r == nil || r.Command != "cat build/a.css build/b.css > static/bundle.css && echo bundled" {
+		t.Fatalf("unexpected record of the bundle %+v", r)
+	}
+	if len(r.Inputs) != 2 || r.Inputs["build/a.css"].Size != 2 || r.Outputs["static/bundle.css"].Size != 4 {
+		t.Errorf("unexpected fingerprints of the bundle %+v", r)
+	}
+	if !strings.HasSuffix(out.String(), "cat build/a.css build/b.css > static/bundle.css && echo bundled\nbundled\n") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestExecutorFailure(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer os.RemoveAll(dir)
+	source := `
+: a.txt |> echo partial > %o && false |> a.out
+: a.out |> cp %f %o |> a2.out
+: b.txt |> cp %f %o |> b.out
+: b.txt |> echo forgot output |> c.out
+`
+	for _, keepGoing := range []bool{false, true} {
+		g := newTestGraph(t, dir, source)
+		e := NewExecutor()
+		e.Jobs = 1
+		e.Dir = dir
+		e.Output = &bytes.Buffer{}
+		e.KeepGoing = keepGoing
+		result := e.Run(g.Sort())
+		if result.Err() == nil {
+			t.Fatal("expected build error")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a.out")); !os.IsNotExist(err) {
+			t.Errorf("expected partial output of the failed command to be removed, got %v", err)
+		}
+		statuses := []Status{}
+		for _, r := range result.Commands {
+			statuses = append(statuses, r.Status)
+		}
+		expected := []Status{StatusFailed, StatusSkipped, StatusSkipped, StatusSkipped}
+		if keepGoing {
+			expected = []Status{StatusFailed, StatusSkipped, StatusOk, StatusFailed}
+			if err := result.Commands[3].Err; err == nil || err.Error() != "command did not produce c.out" {
+				t.Errorf("expected missing output error, got %v", err)
+			}
+		}
+		for i := range expected {
+			if statuses[i] != expected[i] {
+				t.Errorf("[keep going: %v] expected statuses %v, got %v", keepGoing, expected, statuses)
+				break
+			}
+		}
+	}
+}
+
+// chanWriter sends every write to the channel
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestExecutorOutput(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: a.txt |> echo a1; until [ -f resume ]; do sleep 0.01; done; echo a2; cp %f %o |> a.out
+: b.txt |> echo b1; sleep 0.1; echo b2; cp %f %o |> b.out
+`)
+	e := NewExecutor()
+	e.Jobs = 2
+	e.Dir = dir
+	writes := make(chanWriter, 100)
+	e.Output = writes
+	done := make(chan *Result)
+	go func() {
+		done <- e.Run(g.Sort())
+	}()
+
+	// the first command waits until its output is seen
+	out := ""
+	for !strings.Contains(out, "a1\n") {
+		select {
+		case w := <-writes:
+			out += w
+		case <-time.After(5 * time.Second):
+			ioutil.WriteFile(filepath.Join(dir, "resume"), nil, 0644)
+			<-done
+			t.Fatalf("expected output of the running command, got %q", out)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "resume"), nil, 0644)
+	if err := (<-done).Err(); err != nil {
+		t.Fatal(err)
+	}
+	close(writes)
+	for w := range writes {
+		out += w
+	}
+	if !strings.Contains(out, "a1\na2\n") || !strings.Contains(out, "b1\nb2\n") {
+		t.Errorf("expected output of parallel commands not to interleave, got %q", out)
+	}
+}
+
+func TestExecutorIncremental(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	defer os.RemoveAll(dir)
+	source := `!upper = |> tr a-z A-Z < %f > %o |>
+: foreach *.txt |> !upper |> %B.out
+: a.txt |> cp %f %o |> copy.out
+: a.out |> cut -c1 < %f > %o |> a.first
+: a.first |> cp %f %o |> first.copy
+`
+	root := dir
+	build := func(source string) []Status {
+		st, err := state.Load(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := NewExecutor()
+		e.Dir = dir
+		e.Output = &bytes.Buffer{}
+		e.State = st
+		result := e.Run(newTestGraph(t, dir, source).Sort())
+		if err := result.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Save(); err != nil {
+			t.Fatal(err)
+		}
+		statuses := []Status{}
+		for _, r := range result.Commands {
+			statuses = append(statuses, r.Status)
+		}
+		return statuses
+	}
+	expect := func(name string, statuses []Status, expected ...Status) {
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Errorf("[%s] expected statuses %v, got %v", name, expected, statuses)
+		}
+	}
+	ok, upToDate := StatusOk, StatusUpToDate
+
+	expect("first build", build(source), ok, ok, ok, ok, ok)
+	expect("nothing changed", build(source), upToDate, upToDate, upToDate, upToDate, upToDate)
+
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	expect("touched", build(source), upToDate, upToDate, upToDate, upToDate, upToDate)
+
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("bb\n"), 0644)
+	expect("input changed", build(source), upToDate, ok, upToDate, upToDate, upToDate)
+
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("ab\n"), 0644)
+	expect("identical output", build(source), ok, upToDate, ok, ok, upToDate)
+
+	os.Remove(filepath.Join(dir, "copy.out"))
+	expect("output removed", build(source), upToDate, upToDate, ok, upToDate, upToDate)
+
+	changed := strings.Replace(source, "< %f >", "< %f | sed s/^/-/ >", 1)
+	expect("macro changed", build(changed), ok, ok, upToDate, ok, ok)
+	if content := readFile(t, dir, "b.out"); content != "-BB\n" {
+		t.Errorf("unexpected content of the rebuilt output %q", content)
+	}
+}
+
+func TestExecutorDirtyState(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"a.txt": "a"})
+	defer os.RemoveAll(dir)
+	source := `
+: a.txt |> cp %f %o |> a.out
+: a.txt |> cp %f %o |> b.out
+`
+	g := newTestGraph(t, dir, source)
+	e := NewExecutor()
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.State = state.New(filepath.Join(dir, state.Dir, state.FileName))
+	if err := e.Run(g.Sort()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only a.out is built, but b.out has to remember that a.txt changed
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("aa"), 0644)
+	targets, _ := g.Targets([]string{"a.out"})
+	if status := e.Run(targets).Commands[0].Status; status != StatusOk {
+		t.Errorf("expected a.out to be rebuilt, got %v", status)
+	}
+	result := e.Run(g.Sort())
+	if result.Commands[0].Status != StatusUpToDate || result.Commands[1].Status != StatusOk {
+		t.Errorf("expected only b.out to be rebuilt, got %v and %v", result.Commands[0].Status, result.Commands[1].Status)
+	}
+	if result.Commands[1].Reason != "a.txt changed" {
+		t.Errorf("unexpected reason %q", result.Commands[1].Reason)
+	}
+}
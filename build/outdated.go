@@ -0,0 +1,236 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/vakefile"
+)
+
+// detectChanges compares files of the commands with the last build,
+// consumers and producers of the changed files become dirty.
+// Every known file is checked once and only changed files are hashed,
+// see state.Check, new files change inputs of the rules anyway.
+func (e *Executor) detectChanges(commands []*graph.CommandNode) {
+	files := map[string]*graph.FileNode{}
+	// implicit dependencies are not in the graph
+	implicit := map[string][]*graph.CommandNode{}
+	for _, c := range commands {
+		for _, f := range c.Inputs {
+			files[f.Path] = f
+		}
+		for _, f := range c.Outputs {
+			files[f.Path] = f
+		}
+		if len(c.Command.Outputs) == 0 {
+			continue
+		}
+		if r := e.State.Record(c.Command.Outputs[0]); r != nil {
+			for path := range r.Implicit {
+				implicit[path] = append(implicit[path], c)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(files)+len(implicit))
+	for path := range e.State.Files {
+		_, isFile := files[path]
+		_, isImplicit := implicit[path]
+		if isFile || isImplicit {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		reason := e.checkFile(path, e.State.Files[path])
+		if len(reason) == 0 {
+			continue
+		}
+		if f, ok := files[path]; ok {
+			e.markDirty(f, reason)
+		}
+		for _, c := range implicit[path] {
+			e.markCommandDirty(c, reason)
+		}
+	}
+}
+
+// checkFile compares the file with the known fingerprint,
+// updates it in the state and returns the change
+func (e *Executor) checkFile(path string, known state.Fingerprint) string {
+	current, changed, err := state.Check(e.path(path), known)
+	switch {
+	case os.IsNotExist(err):
+		delete(e.State.Files, path)
+		return fmt.Sprintf("%s was removed", path)
+	case err != nil:
+		delete(e.State.Files, path)
+		return fmt.Sprintf("%s can't be checked: %v", path, err)
+	}
+	// same content could have new mtime
+	e.State.Files[path] = current
+	if changed {
+		return fmt.Sprintf("%s changed", path)
+	}
+	return ""
+}
+
+// markDirty marks the producer and consumers of the file dirty,
+// it's stored in the state, so commands which are not built now
+// will be rebuilt later
+func (e *Executor) markDirty(f *graph.FileNode, reason string) {
+	commands := append([]*graph.CommandNode{}, f.Consumers...)
+	if f.Producer != nil {
+		commands = append(commands, f.Producer)
+	}
+	for _, c := range commands {
+		e.markCommandDirty(c, reason)
+	}
+}
+
+func (e *Executor) markCommandDirty(c *graph.CommandNode, reason string) {
+	for _, output := range c.Command.Outputs {
+		e.State.MarkDirty(output, reason)
+	}
+}
+
+// outdated returns why the command has to run,
+// empty reason means that its outputs are up to date
+func (e *Executor) outdated(c *vakefile.Command) string {
+	if e.State == nil {
+		return "there is no build state"
+	}
+	if len(c.Outputs) == 0 {
+		return "command has no outputs"
+	}
+
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	r := e.State.Record(c.Outputs[0])
+	sameRecord := true
+	for _, output := range c.Outputs[1:] {
+		sameRecord = sameRecord && e.State.Record(output) == r
+	}
+	switch {
+	case r == nil:
+		return fmt.Sprintf("%s was never built", c.Outputs[0])
+	case !sameRecord || len(r.Outputs) != len(c.Outputs):
+		return "outputs of the rule changed"
+	case r.Command != c.Cmd:
+		return fmt.Sprintf("command changed from '%s'", r.Command)
+	case len(r.Dirty) != 0:
+		return r.Dirty
+	case len(r.Inputs) != len(c.Inputs):
+		return "inputs of the rule changed"
+	}
+	for _, input := range c.Inputs {
+		if _, ok := r.Inputs[input]; !ok {
+			return fmt.Sprintf("%s is a new input", input)
+		}
+	}
+	return ""
+}
+
+// fingerprint returns fingerprint of the input seen by the build
+func (e *Executor) fingerprint(path string) (state.Fingerprint, error) {
+	e.stateMu.Lock()
+	f, ok := e.State.Files[path]
+	e.stateMu.Unlock()
+	if ok {
+		return f, nil
+	}
+	return state.Stat(e.path(path))
+}
+
+func (e *Executor) fingerprints(paths []string) (map[string]state.Fingerprint, error) {
+	fingerprints := map[string]state.Fingerprint{}
+	for _, path := range paths {
+		f, err := e.fingerprint(path)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints[path] = f
+	}
+	return fingerprints, nil
+}
+
+// record stores fingerprints of the built command and reports
+// whether its outputs are byte-identical to the previous ones,
+// otherwise consumers of the outputs become dirty
+func (e *Executor) record(node *graph.CommandNode, implicit []string) (bool, error) {
+	c := node.Command
+	inputs, err := e.fingerprints(c.Inputs)
+	if err != nil {
+		return false, err
+	}
+	implicitInputs, err := e.fingerprints(implicit)
+	if err != nil {
+		return false, err
+	}
+	outputs := map[string]state.Fingerprint{}
+	for _, output := range c.Outputs {
+		f, err := state.Stat(e.path(output))
+		if err != nil {
+			return false, err
+		}
+		outputs[output] = f
+	}
+
+	e.stateMu.Lock()
+	defer e.stateMu.Unlock()
+	identical := true
+	for _, output := range node.Outputs {
+		old, ok := e.State.Files[output.Path]
+		if ok && old.SameContent(outputs[output.Path]) {
+			continue
+		}
+		identical = false
+		for _, consumer := range output.Consumers {
+			e.markCommandDirty(consumer, fmt.Sprintf("%s changed", output.Path))
+		}
+	}
+	for path, f := range inputs {
+		e.State.Files[path] = f
+	}
+	for path, f := range implicitInputs {
+		e.State.Files[path] = f
+	}
+	for path, f := range outputs {
+		e.State.Files[path] = f
+	}
+	e.State.Set(&state.Record{
+		Rule:     c.Rule.Pos.String(),
+		Vakefile: e.Vakefile,
+		Command:  c.Cmd,
+		Inputs:   inputs,
+		Outputs:  outputs,
+		Implicit: implicitInputs,
+	})
+	return identical, nil
+}
+
+// Outdated returns reasons to run the commands without running them,
+// commands depending on outdated ones are outdated too.
+// Commands should be in topological order.
+func (e *Executor) Outdated(commands []*graph.CommandNode) map[*graph.CommandNode]string {
+	if e.State != nil {
+		e.detectChanges(commands)
+	}
+	reasons := map[*graph.CommandNode]string{}
+	for _, c := range commands {
+		reason := e.outdated(c.Command)
+		for _, dep := range c.Deps() {
+			if _, ok := reasons[dep]; ok && len(reason) == 0 {
+				reason = fmt.Sprintf("depends on outdated %s", strings.Join(dep.Command.Outputs, " "))
+			}
+		}
+		if len(reason) != 0 {
+			reasons[c] = reason
+		}
+	}
+	return reasons
+}
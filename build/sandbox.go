@@ -0,0 +1,34 @@
+package build
+
+import (
+	"os/exec"
+	"path"
+
+	"github.com/anru/vake/sandbox"
+	"github.com/anru/vake/vakefile"
+)
+
+// sandboxCommand runs args in the sandbox, where inputs of the command
+// are read only and directories of its outputs are writable,
+// the returned function should be called after the command is run
+func (e *Executor) sandboxCommand(c *vakefile.Command, args []string) (*exec.Cmd, func(), error) {
+	root, err := e.root()
+	if err != nil {
+		return nil, nil, err
+	}
+	writable := []string{}
+	seen := map[string]bool{}
+	for _, output := range c.Outputs {
+		dir := path.Dir(output)
+		if !seen[dir] {
+			seen[dir] = true
+			writable = append(writable, dir)
+		}
+	}
+	return sandbox.Command(&sandbox.Spec{
+		Root:     root,
+		Inputs:   c.Inputs,
+		Writable: writable,
+		Args:     args,
+	})
+}
@@ -0,0 +1,81 @@
+package build
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anru/vake/sandbox"
+	"github.com/anru/vake/trace"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is the sandbox helper
+	sandbox.Main()
+	os.Exit(m.Run())
+}
+
+func TestExecutorSandbox(t *testing.T) {
+	if err := sandbox.Check(); err != nil {
+		t.Skip(err)
+	}
+	dir := newTestProject(t, map[string]string{"src/a.txt": "a", "secret.txt": "secret"})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: src/a.txt |> cp %f %o |> out/a.out
+: src/a.txt |> cat %f secret.txt > %o |> out/leak.out
+: src/a.txt |> cp %f %o && echo cache > src/cache.txt |> out/cache.out
+`)
+	e := NewExecutor()
+	e.Jobs = 1
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.KeepGoing = true
+	e.Sandbox = true
+
+	statuses := []Status{}
+	for _, r := range e.Run(g.Sort()).Commands {
+		statuses = append(statuses, r.Status)
+	}
+	if statuses[0] != StatusOk || statuses[1] != StatusFailed || statuses[2] != StatusFailed {
+		t.Errorf("unexpected statuses %v, output: %s", statuses, e.Output)
+	}
+	if content := readFile(t, dir, "out/a.out"); content != "a" {
+		t.Errorf("unexpected output %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "src", "cache.txt")); !os.IsNotExist(err) {
+		t.Errorf("sandboxed command wrote to src: %v", err)
+	}
+}
+
+func TestExecutorSandboxRootOutput(t *testing.T) {
+	if err := sandbox.Check(); err != nil {
+		t.Skip(err)
+	}
+	if !trace.Supported() {
+		t.Skip(trace.ErrUnsupported)
+	}
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "secret.txt": "secret", "src/b.txt": "b"})
+	defer os.RemoveAll(dir)
+	// the project root is writable for outputs next to it
+	g := newTestGraph(t, dir, `
+: a.txt |> cat %f secret.txt > %o |> app.out
+: a.txt |> cp %f %o && echo x > src/cache |> cache.out
+`)
+	e := NewExecutor()
+	e.Jobs = 1
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.KeepGoing = true
+	e.Sandbox = true
+
+	result := e.Run(g.Sort())
+	if r := result.Commands[0]; r.Status != StatusOk || !reflect.DeepEqual(r.Implicit, []string{"secret.txt"}) {
+		t.Errorf("expected implicit dependency on secret.txt, got %v %v: %s", r.Status, r.Implicit, e.Output)
+	}
+	if err := result.Commands[1].Err; err == nil || err.Error() != "command wrote src/cache which is not a declared output" {
+		t.Errorf("expected undeclared output error, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/vakefile"
+)
+
+// WriteScript writes POSIX shell script running the commands in the given
+// order, like the executor does with a single job. Root is a path of the
+// project relative to the directory of the script.
+func (e *Executor) WriteScript(w io.Writer, commands []*graph.CommandNode, root string) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n# Generated by vake, do not edit\nset -e\n")
+	if root == "." {
+		b.WriteString("cd \"$(dirname \"$0\")\"\n")
+	} else {
+		fmt.Fprintf(&b, "cd \"$(dirname \"$0\")\"/%s\n", vakefile.ShellQuote(root))
+	}
+
+	shell := []string{}
+	for _, arg := range e.shell() {
+		shell = append(shell, vakefile.ShellQuote(arg))
+	}
+	created := map[string]bool{".": true}
+	for _, c := range commands {
+		fmt.Fprintf(&b, "\n# %s\n", c.Command.Rule.Pos)
+		dirs := []string{}
+		for _, output := range c.Command.Outputs {
+			if dir := path.Dir(output); !created[dir] {
+				created[dir] = true
+				dirs = append(dirs, vakefile.ShellQuote(dir))
+			}
+		}
+		if len(dirs) != 0 {
+			fmt.Fprintf(&b, "mkdir -p %s\n", strings.Join(dirs, " "))
+		}
+		fmt.Fprintf(&b, "%s %s\n", strings.Join(shell, " "), vakefile.ShellQuote(c.Command.Cmd))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,52 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteScript(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"src/a.css": "a\n", "src/b c.css": "b\n"})
+	defer os.RemoveAll(dir)
+	g := newTestGraph(t, dir, `
+: foreach src/*.css |> tr a-z A-Z < %f > %o |> build/%B.css
+: build/*.css |> cat %f > %o |> static/bundle.css
+`)
+	e := NewExecutor()
+	script := &bytes.Buffer{}
+	if err := e.WriteScript(script, g.Sort(), ".."); err != nil {
+		t.Fatal(err)
+	}
+	expected := `#!/bin/sh
+# Generated by vake, do not edit
+set -e
+cd "$(dirname "$0")"/..
+
+# Vakefile:2
+mkdir -p build
+sh -c 'tr a-z A-Z < src/a.css > build/a.css'
+
+# Vakefile:2
+sh -c 'tr a-z A-Z < '\''src/b c.css'\'' > '\''build/b c.css'\'''
+
+# Vakefile:3
+mkdir -p static
+sh -c 'cat build/a.css '\''build/b c.css'\'' > static/bundle.css'
+`
+	if script.String() != expected {
+		t.Errorf("expected script:\n%s\ngot:\n%s", expected, script)
+	}
+
+	os.MkdirAll(filepath.Join(dir, "ci"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "ci", "build.sh"), script.Bytes(), 0755)
+	if out, err := exec.Command("sh", filepath.Join(dir, "ci", "build.sh")).CombinedOutput(); err != nil {
+		t.Fatalf("script failed: %v: %s", err, out)
+	}
+	if content := readFile(t, dir, "static/bundle.css"); content != "A\nB\n" {
+		t.Errorf("unexpected bundle %q", content)
+	}
+}
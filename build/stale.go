@@ -0,0 +1,66 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/state"
+)
+
+// RemoveStale deletes outputs of previous builds of the vakefile which are
+// not produced by any rule of the graph anymore and returns removed files.
+// Outputs modified since the build or used as sources of the graph now
+// are kept and returned, vake just forgets them.
+func (e *Executor) RemoveStale(g *graph.Graph) (removed, kept []string, err error) {
+	removed = []string{}
+	kept = []string{}
+	if e.State == nil {
+		return removed, kept, nil
+	}
+	for _, output := range e.State.Outputs() {
+		r := e.State.Record(output)
+		if r.Vakefile != e.Vakefile {
+			continue
+		}
+		if f := g.File(output); f != nil {
+			if !f.Generated() {
+				e.State.Remove(output)
+				kept = append(kept, output)
+			}
+			continue
+		}
+		_, modified, err := state.Check(e.path(output), r.Outputs[output])
+		switch {
+		case os.IsNotExist(err):
+			e.State.Remove(output)
+			continue
+		case err != nil:
+			return removed, kept, err
+		case modified:
+			e.State.Remove(output)
+			kept = append(kept, output)
+			continue
+		}
+		if err := os.Remove(e.path(output)); err != nil && !os.IsNotExist(err) {
+			return removed, kept, err
+		}
+		e.State.Remove(output)
+		removed = append(removed, output)
+		e.removeEmptyDirs(filepath.Dir(e.path(output)))
+	}
+	return removed, kept, nil
+}
+
+// removeEmptyDirs removes dir and its parents while they are empty
+func (e *Executor) removeEmptyDirs(dir string) {
+	root := e.path(".")
+	for {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") || os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,70 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anru/vake/state"
+)
+
+func TestRemoveStale(t *testing.T) {
+	dir := newTestProject(t, map[string]string{"a.txt": "a", "b.txt": "b"})
+	defer os.RemoveAll(dir)
+
+	e := NewExecutor()
+	e.Dir = dir
+	e.Output = &bytes.Buffer{}
+	e.State = state.New(filepath.Join(dir, state.Dir, state.FileName))
+	g := newTestGraph(t, dir, `
+: foreach *.txt |> cp %f %o |> out/sub/%B.out
+: a.txt |> cp %f %o |> edited.out
+: a.txt |> cp %f %o |> a.copy
+: b.txt |> cp %f %o |> b.copy
+`)
+	if err := e.Run(g.Sort()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "edited.out"), []byte("edited by hand"), 0644)
+
+	// outputs of other vakefiles are not stale
+	other := NewExecutor()
+	other.Dir = dir
+	other.Output = &bytes.Buffer{}
+	other.State = e.State
+	other.Vakefile = "Other"
+	if err := other.Run(newTestGraph(t, dir, ": b.txt |> cp %f %o |> other.out").Sort()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	g = newTestGraph(t, dir, `
+: foreach *.txt |> cp %f %o |> renamed/%B.out
+: a.copy |> cp %f %o |> d.out
+: b.txt |> cp %f %o |> b.copy
+`)
+	removed, kept, err := e.RemoveStale(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"out/sub/a.out", "out/sub/b.out"}; !reflect.DeepEqual(removed, expected) {
+		t.Errorf("expected removed files %v, got %v", expected, removed)
+	}
+	if expected := []string{"a.copy", "edited.out"}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected kept files %v, got %v", expected, kept)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("expected empty output dirs to be removed, got %v", err)
+	}
+	if content := readFile(t, dir, "edited.out"); content != "edited by hand" {
+		t.Errorf("modified output should be kept, got %q", content)
+	}
+	if content := readFile(t, dir, "a.copy"); content != "a" {
+		t.Errorf("output used as a source should be kept, got %q", content)
+	}
+	if outputs := e.State.Outputs(); !reflect.DeepEqual(outputs, []string{"b.copy", "other.out"}) {
+		t.Errorf("unexpected outputs in the state %v", outputs)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anru/vake/build"
+	"github.com/anru/vake/glob"
+	"github.com/anru/vake/graph"
+	"github.com/anru/vake/help"
+	"github.com/anru/vake/runner"
+	"github.com/anru/vake/sandbox"
+	"github.com/anru/vake/state"
+	"github.com/anru/vake/trace"
+	"github.com/anru/vake/vakefile"
+)
+
+type command func(c *context, args []string) error
+
+var commands = map[string]command{
+	"build":    cmdBuild,
+	"run":      cmdRun,
+	"help":     cmdHelp,
+	"graph":    cmdGraph,
+	"generate": cmdGenerate,
+	"parse":    cmdParse,
+	"refactor": cmdRefactor,
+}
+
+func isTerminal(w interface{}) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *context) runner() *runner.Runner {
+	r := runner.New(c.nodes, c.env.Vars())
+	r.Jobs = c.jobs
+	r.Stdout = c.stdout
+	r.Stderr = c.stderr
+	return r
+}
+
+func cmdRun(c *context, args []string) error {
+	if len(args) == 0 {
+		return usagef("run: label is required")
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	r := c.runner()
+	if err := r.CheckArgs(args[0], args[1:]); err != nil {
+		return usagef("run: %v", err)
+	}
+	return r.Run(args[0], args[1:]...)
+}
+
+func cmdHelp(c *context, args []string) error {
+	if len(args) > 1 {
+		return usagef("help: expected at most one label")
+	}
+	if err := c.load(); err != nil {
+		if len(args) == 0 {
+			// there is no vakefile, but we still can help with vake itself
+			fmt.Fprintln(c.stdout, usage)
+			return nil
+		}
+		return err
+	}
+	r := c.runner()
+	if len(args) == 0 {
+		return help.List(c.stdout, r.Labels())
+	}
+	label, err := r.Label(args[0])
+	if err != nil {
+		return usagef("help: %v", err)
+	}
+	return help.Describe(c.stdout, label, isTerminal(c.stdout))
+}
+
+func cmdParse(c *context, args []string) error {
+	if len(args) != 0 {
+		return usagef("parse: unexpected arguments")
+	}
+	if err := c.load(); err != nil {
+		return err
+	}
+	for _, node := range c.nodes {
+		fmt.Fprintln(c.stdout, node)
+	}
+	return nil
+}
+
+// graph expands rules against the working tree
+func (c *context) graph() (*graph.Graph, error) {
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	tree, err := glob.Scan(".")
+	if err != nil {
+		return nil, err
+	}
+	commands, err := vakefile.ExpandRules(c.nodes, tree)
+	if err != nil {
+		return nil, &vakefileError{err}
+	}
+	g, err := graph.New(commands)
+	if err != nil {
+		return nil, &vakefileError{err}
+	}
+	c.logf("expanded %d commands from %d files", len(commands), len(tree.Files))
+	return g, nil
+}
+
+func (c *context) executor() *build.Executor {
+	e := build.NewExecutor()
+	e.Jobs = c.jobs
+	e.Vakefile = filepath.ToSlash(filepath.Clean(c.file))
+	e.KeepGoing = c.keepGoing
+	e.Output = c.stdout
+	// SHELL variable of the vakefile overrides the default shell
+	if shell, ok := c.env.Vars()["SHELL"]; ok && len(strings.Fields(shell)) != 0 {
+		e.Shell = strings.Fields(shell)
+	}
+	if c.sandbox {
+		if err := sandbox.Check(); err != nil {
+			fmt.Fprintf(c.stderr, "vake: warning: sandbox is not available, commands run without it: %v\n", err)
+		} else {
+			e.Sandbox = true
+		}
+	}
+	return e
+}
+
+func cmdBuild(c *context, args []string) error {
+	g, err := c.graph()
+	if err != nil {
+		return err
+	}
+	st, err := state.Load(".")
+	if err != nil {
+		return err
+	}
+	e := c.executor()
+	e.State = st
+	removed, kept, err := e.RemoveStale(g)
+	for _, path := range removed {
+		fmt.Fprintf(c.stdout, "removed %s\n", path)
+	}
+	for _, path := range kept {
+		fmt.Fprintf(c.stdout, "kept %s, it's not built anymore, but it was modified or it's a source now\n", path)
+	}
+	if err != nil {
+		return err
+	}
+	if len(removed) != 0 {
+		// removed outputs could be matched by patterns of the rules
+		if g, err = c.graph(); err != nil {
+			return err
+		}
+	}
+	targets, err := g.Targets(args)
+	if err != nil {
+		return usagef("build: %v", err)
+	}
+	e.Graph = g
+	switch {
+	case !e.Trace && e.Sandbox:
+		fmt.Fprintf(c.stderr, "vake: warning: %v, undeclared accesses to directories of outputs are not detected\n", trace.ErrUnsupported)
+	case !e.Trace:
+		fmt.Fprintf(c.stderr, "vake: warning: %v, undeclared inputs and outputs are not detected\n", trace.ErrUnsupported)
+	}
+	result := e.Run(targets)
+	// outputs built before a failure are worth remembering too
+	if err := st.Save(); err != nil {
+		return err
+	}
+	for _, r := range result.Commands {
+		if len(r.Reason) != 0 {
+			c.logf("%s: run because %s", r.Node.Command.Rule.Pos, r.Reason)
+		}
+		for _, path := range r.Implicit {
+			c.logf("%s: implicit dependency on %s", r.Node.Command.Rule.Pos, path)
+		}
+		if r.Identical {
+			c.logf("%s: outputs are unchanged", r.Node.Command.Rule.Pos)
+		}
+	}
+	c.logf("%d commands succeeded, %d up to date, %d failed, %d skipped",
+		result.Count(build.StatusOk), result.Count(build.StatusUpToDate),
+		result.Count(build.StatusFailed), result.Count(build.StatusSkipped))
+	return result.Err()
+}
+
+var graphFormats = map[string]func(*graph.View, io.Writer) error{
+	"dot":     (*graph.View).WriteDot,
+	"mermaid": (*graph.View).WriteMermaid,
+	"json":    (*graph.View).WriteJSON,
+}
+
+func cmdGraph(c *context, args []string) error {
+	flags := flag.NewFlagSet("graph", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	format := flags.String("format", "dot", "")
+	opts := graph.ViewOptions{}
+	flags.BoolVar(&opts.Collapse, "collapse", false, "")
+	flags.IntVar(&opts.Depth, "depth", 0, "")
+	dirtyOnly := flags.Bool("dirty", false, "")
+	if err := flags.Parse(args); err != nil {
+		return usagef("graph: %v", err)
+	}
+	write, ok := graphFormats[*format]
+	if !ok {
+		return usagef("graph: unknown format %s, expected dot, mermaid or json", *format)
+	}
+	if opts.Depth < 0 {
+		return usagef("graph: -depth should not be negative, got %d", opts.Depth)
+	}
+
+	g, err := c.graph()
+	if err != nil {
+		return err
+	}
+	for _, path := range flags.Args() {
+		f := g.File(path)
+		switch {
+		case f == nil:
+			return usagef("graph: there is no file %s in the graph", path)
+		case f.Generated():
+			opts.Targets = append(opts.Targets, f.Producer)
+		default:
+			opts.Targets = append(opts.Targets, f.Consumers...)
+		}
+	}
+	if *dirtyOnly {
+		st, err := state.Load(".")
+		if err != nil {
+			return err
+		}
+		e := build.NewExecutor()
+		e.State = st
+		outdated := e.Outdated(g.Sort())
+		opts.Filter = func(c *graph.CommandNode) bool {
+			_, ok := outdated[c]
+			return ok
+		}
+	}
+	return write(g.View(opts), c.stdout)
+}
+
+func cmdGenerate(c *context, args []string) error {
+	if len(args) != 1 {
+		return usagef("generate: script path is required")
+	}
+	g, err := c.graph()
+	if err != nil {
+		return err
+	}
+	script, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Rel(filepath.Dir(script), wd)
+	if err != nil {
+		return err
+	}
+	commands := g.Sort()
+	var b bytes.Buffer
+	if err := c.executor().WriteScript(&b, commands, filepath.ToSlash(root)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(script, b.Bytes(), 0755); err != nil {
+		return err
+	}
+	c.logf("generated %s with %d commands", args[0], len(commands))
+	return nil
+}
+
+func cmdRefactor(c *context, args []string) error {
+	if len(args) != 0 {
+		return usagef("refactor: unexpected arguments")
+	}
+	g, err := c.graph()
+	if err != nil {
+		return err
+	}
+	st, err := state.Load(".")
+	if err != nil {
+		return err
+	}
+	if len(st.Records) == 0 {
+		return fmt.Errorf("refactor: there is no build state to compare with, run vake build first")
+	}
+	e := build.NewExecutor()
+	e.State = st
+	diffs := e.Diff(g)
+	for _, diff := range diffs {
+		fmt.Fprintln(c.stdout, diff)
+	}
+	if len(diffs) != 0 {
+		return fmt.Errorf("refactor: %d differences with the last build", len(diffs))
+	}
+	fmt.Fprintf(c.stdout, "no differences with the last build, commands checked: %d\n", len(g.Commands))
+	return nil
+}
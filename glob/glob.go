@@ -0,0 +1,177 @@
+// Package glob expands dependency templates over the working tree
+//
+// Supported syntax:
+//
+//	?       any single character except /
+//	*       any sequence of characters except /
+//	**      any number of directories
+//	[a-z]   character class, [!a-z] or [^a-z] negates it
+//	{a,b}   alternatives, may be nested
+//	!p      negation, the pattern matches paths not matched by p
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Pattern struct {
+	Source  string
+	Negated bool
+	re      *regexp.Regexp
+}
+
+// Match is a file matched by the pattern
+type Match struct {
+	Path string
+	// text matched by the first wildcard of the pattern
+	Glob string
+	// path is given literally, not by wildcards
+	Literal bool
+}
+
+// Compile translates glob pattern to the regular expression
+func Compile(pattern string) (*Pattern, error) {
+	p := &Pattern{Source: pattern}
+	if strings.HasPrefix(pattern, "!") {
+		p.Negated = true
+		pattern = pattern[1:]
+	}
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("glob: empty pattern %q", p.Source)
+	}
+	expr, err := translate(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob: %q: %v", p.Source, err)
+	}
+	p.re, err = regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("glob: %q: %v", p.Source, err)
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile but panics on error
+func MustCompile(pattern string) *Pattern {
+	p, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Match reports whether slash separated path matches the pattern
+func (p *Pattern) Match(path string) bool {
+	return p.re.MatchString(path) != p.Negated
+}
+
+// Capture returns text matched by the first wildcard of the pattern
+func (p *Pattern) Capture(path string) (string, bool) {
+	m := p.re.FindStringSubmatch(path)
+	if m == nil || p.Negated {
+		return "", m == nil && p.Negated
+	}
+	if len(m) > 1 {
+		return m[1], true
+	}
+	return "", true
+}
+
+func (p *Pattern) String() string {
+	return p.Source
+}
+
+// HasMagic reports whether pattern contains any glob syntax
+func HasMagic(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[{\\") || strings.HasPrefix(pattern, "!")
+}
+
+func translate(pattern string) (string, error) {
+	var b strings.Builder
+	braces := 0
+	// the first wildcard is captured
+	captured := false
+	capture := func(expr string) {
+		if captured {
+			b.WriteString(expr)
+			return
+		}
+		captured = true
+		b.WriteString("(" + expr + ")")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				start := i == 0 || pattern[i-1] == '/'
+				for i+1 < len(pattern) && pattern[i+1] == '*' {
+					i++
+				}
+				end := i+1 == len(pattern) || pattern[i+1] == '/'
+				switch {
+				case start && end && i+1 < len(pattern):
+					// **/ - zero or more directories
+					capture("(?:[^/]*(?:/[^/]*)*/)?")
+					i++
+				case start && end:
+					// trailing ** - everything below
+					capture(".*")
+				default:
+					// ** inside of the segment works as *
+					capture("[^/]*")
+				}
+				continue
+			}
+			capture("[^/]*")
+		case '?':
+			capture("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == 0 && i+2 < len(pattern) {
+				// []abc] - ] is the first character of the class
+				end = strings.IndexByte(pattern[i+2:], ']') + 1
+			}
+			if end <= 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			b.WriteByte('[')
+			if class[0] == '!' || class[0] == '^' {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			b.WriteString(strings.Replace(strings.Replace(class, `\`, `\\`, -1), "[", `\[`, -1))
+			b.WriteByte(']')
+			i += end + 1
+		case '{':
+			braces++
+			b.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				return "", fmt.Errorf("unexpected }")
+			}
+			braces--
+			b.WriteByte(')')
+		case ',':
+			if braces > 0 {
+				b.WriteByte('|')
+			} else {
+				b.WriteByte(',')
+			}
+		case '\\':
+			if i+1 == len(pattern) {
+				return "", fmt.Errorf("trailing \\")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if braces != 0 {
+		return "", fmt.Errorf("unterminated {")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,180 @@
+package glob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type matchCase struct {
+	path  string
+	match bool
+}
+
+var matchTestCases = map[string][]matchCase{
+	"src/*.css": {
+		{"src/a.css", true},
+		{"src/.css", true},
+		{"src/foo/b.css", false},
+		{"src/a.js", false},
+	},
+	"src/**/*.css": {
+		{"src/a.css", true},
+		{"src/foo/b.css", true},
+		{"src/foo/bar/c.css", true},
+		{"srcx/a.css", false},
+	},
+	"**/*.js": {
+		{"a.js", true},
+		{"x/y/a.js", true},
+		{"a.css", false},
+	},
+	"src/**": {
+		{"src/a", true},
+		{"src/a/b/c", true},
+		{"lib/a", false},
+	},
+	"src/a**.js": {
+		{"src/abc.js", true},
+		{"src/a/b.js", false},
+	},
+	"file?.txt": {
+		{"file1.txt", true},
+		{"file12.txt", false},
+		{"file/.txt", false},
+	},
+	"[a-c]x[!0-9]": {
+		{"axy", true},
+		{"cxz", true},
+		{"dxy", false},
+		{"ax1", false},
+	},
+	"[]x]": {
+		{"]", true},
+		{"x", true},
+	},
+	"src/*.{js,jsx,{ts,tsx}}": {
+		{"src/a.js", true},
+		{"src/a.jsx", true},
+		{"src/a.tsx", true},
+		{"src/a.css", false},
+	},
+	"!*.test.js": {
+		{"a.test.js", false},
+		{"a.js", true},
+	},
+	`a\*b.c`: {
+		{"a*b.c", true},
+		{"axb.c", false},
+		{"a*bxc", false},
+	},
+}
+
+func TestMatch(t *testing.T) {
+	for pattern, cases := range matchTestCases {
+		p, err := Compile(pattern)
+		if err != nil {
+			t.Errorf("[%s] %v", pattern, err)
+			continue
+		}
+		for _, c := range cases {
+			if p.Match(c.path) != c.match {
+				t.Errorf("[%s] expected match of %s to be %v", pattern, c.path, c.match)
+			}
+		}
+	}
+}
+
+var invalidPatterns = []string{"", "!", "[abc", "{a,b", "a}", `a\`}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range invalidPatterns {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("[%s] expected error", pattern)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tree := NewTree([]string{
+		"src/foo/b.css",
+		"src/a.css",
+		"src/a.js",
+		"README.md",
+	})
+	files, err := tree.Glob("src/**/*.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"src/a.css", "src/foo/b.css"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+	if !tree.Has("README.md") || tree.Has("src") {
+		t.Error("unexpected Has result")
+	}
+}
+
+func TestScan(t *testing.T) {
+	root, err := ioutil.TempDir("", "vake-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, file := range []string{"b/c.txt", "a.txt", ".git/HEAD", "b/.vake/state"} {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, nil, 0644)
+	}
+	tree, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"a.txt", "b/c.txt"}; !reflect.DeepEqual(tree.Files, expected) {
+		t.Errorf("expected %v, got %v", expected, tree.Files)
+	}
+}
+
+type expandCase struct {
+	patterns []string
+	excludes []string
+	expected []string
+}
+
+var expandTestCases = []expandCase{
+	{[]string{"src/*.js"}, []string{"src/*.test.js"}, []string{"src/a.js", "src/b.js"}},
+	{[]string{"src/*.js", "!src/*.test.js"}, nil, []string{"src/a.js", "src/b.js"}},
+	{[]string{"src/b.js", "src/*.js", "./gen/x.js"}, []string{"src/a*"}, []string{"gen/x.js", "src/b.js", "src/b.test.js"}},
+	{[]string{"lib/*.js"}, nil, []string{}},
+}
+
+func TestExpand(t *testing.T) {
+	tree := NewTree([]string{"src/b.test.js", "src/a.js", "src/b.js", "src/a.test.js", "src/c.css"})
+	for _, c := range expandTestCases {
+		files, err := tree.Expand(c.patterns, c.excludes)
+		if err != nil {
+			t.Errorf("%v: %v", c.patterns, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, c.expected) {
+			t.Errorf("%v ^%v: expected %v, got %v", c.patterns, c.excludes, c.expected, files)
+		}
+	}
+}
+
+var captureTestCases = map[string][2]string{
+	"src/*.css":        {"src/main.css", "main"},
+	"src/**/*.css":     {"src/a/b/main.css", "a/b/"},
+	"img/?x.{png,jpg}": {"img/2x.png", "2"},
+	"static/app.js":    {"static/app.js", ""},
+}
+
+func TestCapture(t *testing.T) {
+	for pattern, c := range captureTestCases {
+		glob, ok := MustCompile(pattern).Capture(c[0])
+		if !ok || glob != c[1] {
+			t.Errorf("[%s] expected capture %q of %s, got %q", pattern, c[1], c[0], glob)
+		}
+	}
+}
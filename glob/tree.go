@@ -0,0 +1,180 @@
+package glob
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// SkipDirs are never scanned
+var SkipDirs = map[string]bool{
+	".git":  true,
+	".hg":   true,
+	".svn":  true,
+	".vake": true,
+}
+
+// Tree is a sorted list of the project files, paths are slash separated
+// and relative to the project root
+type Tree struct {
+	Files []string
+}
+
+// NewTree creates tree from the list of files
+func NewTree(files []string) *Tree {
+	sorted := append([]string{}, files...)
+	sort.Strings(sorted)
+	return &Tree{Files: sorted}
+}
+
+// Scan walks the working tree
+func Scan(root string) (*Tree, error) {
+	files := []string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && SkipDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewTree(files), nil
+}
+
+// Glob returns sorted list of files matched by the pattern
+func (t *Tree) Glob(pattern string) ([]string, error) {
+	p, err := Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return t.Match(p), nil
+}
+
+// Match returns sorted list of files matched by the pattern
+func (t *Tree) Match(p *Pattern) []string {
+	out := []string{}
+	for _, file := range t.Files {
+		if p.Match(file) {
+			out = append(out, file)
+		}
+	}
+	return out
+}
+
+// Has reports whether file is in the tree
+func (t *Tree) Has(file string) bool {
+	i := sort.SearchStrings(t.Files, file)
+	return i < len(t.Files) && t.Files[i] == file
+}
+
+// Add adds files to the tree
+func (t *Tree) Add(files ...string) {
+	for _, file := range files {
+		i := sort.SearchStrings(t.Files, file)
+		if i < len(t.Files) && t.Files[i] == file {
+			continue
+		}
+		t.Files = append(t.Files, "")
+		copy(t.Files[i+1:], t.Files[i:])
+		t.Files[i] = file
+	}
+}
+
+// Clone returns copy of the tree
+func (t *Tree) Clone() *Tree {
+	return &Tree{Files: append([]string{}, t.Files...)}
+}
+
+// Expand returns sorted union of files matched by patterns except excluded ones,
+// negated patterns are exclusions as well. Literal paths are kept even
+// if they are missing in the tree: they may be generated later.
+func (t *Tree) Expand(patterns, excludes []string) ([]string, error) {
+	matches, err := t.ExpandMatches(patterns, excludes)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.Path
+	}
+	return out, nil
+}
+
+// ExpandMatches is like Expand but returns glob captures as well,
+// the first matched pattern wins
+func (t *Tree) ExpandMatches(patterns, excludes []string) ([]Match, error) {
+	exclude := []*Pattern{}
+	for _, pattern := range excludes {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, p)
+	}
+
+	include := []*Pattern{}
+	literals := []string{}
+	for _, pattern := range patterns {
+		if !HasMagic(pattern) {
+			literals = append(literals, path.Clean(pattern))
+			continue
+		}
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if p.Negated {
+			// !p excludes files matched by p
+			p.Negated = false
+			exclude = append(exclude, p)
+			continue
+		}
+		include = append(include, p)
+	}
+
+	isExcluded := func(file string) bool {
+		for _, p := range exclude {
+			if p.Match(file) {
+				return true
+			}
+		}
+		return false
+	}
+
+	seen := map[string]bool{}
+	out := []Match{}
+	add := func(m Match) {
+		if !seen[m.Path] && !isExcluded(m.Path) {
+			seen[m.Path] = true
+			out = append(out, m)
+		}
+	}
+	for _, file := range literals {
+		add(Match{Path: file, Literal: true})
+	}
+	for _, file := range t.Files {
+		for _, p := range include {
+			if glob, ok := p.Capture(file); ok {
+				add(Match{Path: file, Glob: glob})
+				break
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Path < out[j].Path
+	})
+	return out, nil
+}
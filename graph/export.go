@@ -0,0 +1,202 @@
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anru/vake/vakefile"
+)
+
+// ViewOptions select the part of the graph to export
+type ViewOptions struct {
+	// Targets limit the view to commands around them, all commands without targets
+	Targets []*CommandNode
+	// Depth is the maximum distance in commands from the targets, 0 is unlimited
+	Depth int
+	// Collapse shows instances of a foreach rule as a single command
+	Collapse bool
+	// Filter drops commands when returns false
+	Filter func(*CommandNode) bool
+}
+
+type ViewNode struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"` // "command" or "file"
+	Label string `json:"label"`
+	// Generated is set for files produced by commands
+	Generated bool `json:"generated,omitempty"`
+}
+
+type ViewEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// View is the exported part of the graph
+type View struct {
+	Nodes []*ViewNode `json:"nodes"`
+	Edges []*ViewEdge `json:"edges"`
+}
+
+// around returns the targets with commands they depend on and commands
+// depending on them, at most depth commands away from the targets
+func around(targets []*CommandNode, depth int) []*CommandNode {
+	seen := map[*CommandNode]bool{}
+	out := []*CommandNode{}
+	ancestors := walk(targets, depth, (*CommandNode).Deps)
+	descendants := walk(targets, depth, (*CommandNode).dependents)
+	for _, commands := range [][]*CommandNode{targets, ancestors, descendants} {
+		for _, c := range commands {
+			if !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	sortByID(out)
+	return out
+}
+
+// viewBuilder assigns ids to nodes and removes duplicated edges
+type viewBuilder struct {
+	view  *View
+	ids   map[string]string
+	edges map[ViewEdge]bool
+	// collapsed are foreach rules shown as a single command
+	collapsed map[*vakefile.RuleNode]bool
+}
+
+func (b *viewBuilder) node(key, typ, label string, generated bool) string {
+	if id, ok := b.ids[key]; ok {
+		return id
+	}
+	id := fmt.Sprintf("%c%d", typ[0], len(b.view.Nodes))
+	b.ids[key] = id
+	b.view.Nodes = append(b.view.Nodes, &ViewNode{ID: id, Type: typ, Label: label, Generated: generated})
+	return id
+}
+
+func (b *viewBuilder) edge(from, to string) {
+	e := ViewEdge{from, to}
+	if !b.edges[e] {
+		b.edges[e] = true
+		b.view.Edges = append(b.view.Edges, &e)
+	}
+}
+
+func (b *viewBuilder) command(c *CommandNode) string {
+	rule := c.Command.Rule
+	if b.collapsed[rule] {
+		return b.node("rule:"+rule.Pos.String(), "command", rule.Command, false)
+	}
+	return b.node(fmt.Sprintf("command:%d", c.ID), "command", c.Command.Cmd, false)
+}
+
+// file returns node of the file, files of collapsed rules are shown
+// by patterns: outputs by the output and sources consumed only by the rule
+// by its inputs
+func (b *viewBuilder) file(f *FileNode) string {
+	if p := f.Producer; p != nil && b.collapsed[p.Command.Rule] {
+		rule := p.Command.Rule
+		return b.node("output:"+rule.Pos.String(), "file", rule.Output, true)
+	}
+	if f.Producer == nil && len(f.Consumers) != 0 {
+		rule := f.Consumers[0].Command.Rule
+		single := b.collapsed[rule]
+		for _, c := range f.Consumers[1:] {
+			single = single && c.Command.Rule == rule
+		}
+		if single {
+			return b.node("input:"+rule.Pos.String(), "file", strings.Join(rule.Inputs, " "), false)
+		}
+	}
+	return b.node("file:"+f.Path, "file", f.Path, f.Generated())
+}
+
+// View returns the part of the graph selected by options
+func (g *Graph) View(opts ViewOptions) *View {
+	commands := g.Commands
+	if len(opts.Targets) != 0 {
+		commands = around(opts.Targets, opts.Depth)
+	}
+	b := &viewBuilder{
+		view:      &View{Nodes: []*ViewNode{}, Edges: []*ViewEdge{}},
+		ids:       map[string]string{},
+		edges:     map[ViewEdge]bool{},
+		collapsed: map[*vakefile.RuleNode]bool{},
+	}
+	if opts.Collapse {
+		for _, c := range commands {
+			if rule := c.Command.Rule; rule.Foreach {
+				b.collapsed[rule] = true
+			}
+		}
+	}
+	for _, c := range commands {
+		if opts.Filter != nil && !opts.Filter(c) {
+			continue
+		}
+		id := b.command(c)
+		for _, input := range c.Inputs {
+			b.edge(b.file(input), id)
+		}
+		for _, output := range c.Outputs {
+			b.edge(id, b.file(output))
+		}
+	}
+	return b.view
+}
+
+// WriteJSON writes the view as JSON object with nodes and edges
+func (v *View) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WriteDot writes the view in Graphviz dot format
+func (v *View) WriteDot(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph vake {\n  rankdir=LR;\n")
+	for _, n := range v.Nodes {
+		attrs := "shape=box"
+		if n.Type == "file" {
+			attrs = "shape=ellipse"
+			if n.Generated {
+				attrs += ", style=filled"
+			}
+		}
+		fmt.Fprintf(&b, "  %s [%s, label=%q];\n", n.ID, attrs, n.Label)
+	}
+	for _, e := range v.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the view as Mermaid flowchart
+func (v *View) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	escape := strings.NewReplacer(`"`, "#quot;")
+	for _, n := range v.Nodes {
+		label := escape.Replace(n.Label)
+		switch {
+		case n.Type == "command":
+			fmt.Fprintf(&b, "  %s[\"%s\"]\n", n.ID, label)
+		case n.Generated:
+			fmt.Fprintf(&b, "  %s[(\"%s\")]\n", n.ID, label)
+		default:
+			fmt.Fprintf(&b, "  %s([\"%s\"])\n", n.ID, label)
+		}
+	}
+	for _, e := range v.Edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", e.From, e.To)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,80 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	g, err := newTestGraph(t, testVakefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := map[string]struct {
+		opts  ViewOptions
+		nodes int
+		edges int
+	}{
+		"all":         {ViewOptions{}, 13, 12},
+		"collapse":    {ViewOptions{Collapse: true}, 10, 9},
+		"around app":  {ViewOptions{Targets: []*CommandNode{g.Commands[3]}, Depth: 1}, 6, 5},
+		"app only":    {ViewOptions{Targets: []*CommandNode{g.Commands[3]}, Depth: 0, Filter: func(c *CommandNode) bool { return c.ID == 3 }}, 3, 2},
+		"css around":  {ViewOptions{Targets: []*CommandNode{g.Commands[0]}, Depth: 1, Collapse: true}, 5, 4},
+		"bundle deps": {ViewOptions{Targets: []*CommandNode{g.Commands[2]}}, 11, 10},
+	}
+	for name, tc := range testCases {
+		v := g.View(tc.opts)
+		if len(v.Nodes) != tc.nodes || len(v.Edges) != tc.edges {
+			buf := &bytes.Buffer{}
+			v.WriteMermaid(buf)
+			t.Errorf("[%s] expected %d nodes and %d edges, got %d and %d:\n%s", name, tc.nodes, tc.edges, len(v.Nodes), len(v.Edges), buf)
+		}
+	}
+}
+
+func TestViewFormats(t *testing.T) {
+	g, err := newTestGraph(t, `: foreach src/*.css |> csso "%f" -o %o |> build/%B.min.css`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := g.View(ViewOptions{Collapse: true})
+
+	dot := &bytes.Buffer{}
+	v.WriteDot(dot)
+	expected := `digraph vake {
+  rankdir=LR;
+  c0 [shape=box, label="csso \"%f\" -o %o"];
+  f1 [shape=ellipse, label="src/*.css"];
+  f2 [shape=ellipse, style=filled, label="build/%B.min.css"];
+  f1 -> c0;
+  c0 -> f2;
+}
+`
+	if dot.String() != expected {
+		t.Errorf("expected dot:\n%s\ngot:\n%s", expected, dot)
+	}
+
+	mermaid := &bytes.Buffer{}
+	v.WriteMermaid(mermaid)
+	expected = `flowchart LR
+  c0["csso #quot;%f#quot; -o %o"]
+  f1(["src/*.css"])
+  f2[("build/%B.min.css")]
+  f1 --> c0
+  c0 --> f2
+`
+	if mermaid.String() != expected {
+		t.Errorf("expected mermaid:\n%s\ngot:\n%s", expected, mermaid)
+	}
+
+	out := &bytes.Buffer{}
+	v.WriteJSON(out)
+	decoded := &View{}
+	if err := json.Unmarshal(out.Bytes(), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != 3 || decoded.Nodes[2].Label != "build/%B.min.css" || !decoded.Nodes[2].Generated {
+		t.Errorf("unexpected json %s", out)
+	}
+}
@@ -0,0 +1,158 @@
+// Package graph builds dependency graph of expanded rules
+//
+// Graph is bipartite: command nodes depend on their input files
+// and file nodes depend on the command which produces them.
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anru/vake/vakefile"
+)
+
+type CommandNode struct {
+	// ID is the index of command in declaration order
+	ID      int
+	Command *vakefile.Command
+	Inputs  []*FileNode
+	Outputs []*FileNode
+}
+
+func (c *CommandNode) String() string {
+	return c.Command.Cmd
+}
+
+// Deps returns commands producing inputs of the command
+func (c *CommandNode) Deps() []*CommandNode {
+	seen := map[*CommandNode]bool{}
+	deps := []*CommandNode{}
+	for _, input := range c.Inputs {
+		if p := input.Producer; p != nil && !seen[p] {
+			seen[p] = true
+			deps = append(deps, p)
+		}
+	}
+	sortByID(deps)
+	return deps
+}
+
+type FileNode struct {
+	Path string
+	// Producer is nil for source files
+	Producer  *CommandNode
+	Consumers []*CommandNode
+}
+
+// Generated reports whether file is an output of some command
+func (f *FileNode) Generated() bool {
+	return f.Producer != nil
+}
+
+type Graph struct {
+	// Commands are in declaration order
+	Commands []*CommandNode
+	files    map[string]*FileNode
+}
+
+func sortByID(commands []*CommandNode) {
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].ID < commands[j].ID
+	})
+}
+
+func (g *Graph) file(path string) *FileNode {
+	f, ok := g.files[path]
+	if !ok {
+		f = &FileNode{Path: path}
+		g.files[path] = f
+	}
+	return f
+}
+
+// New links outputs of commands to inputs of other commands,
+// it fails on duplicate producers of the same file and on cycles
+func New(commands []*vakefile.Command) (*Graph, error) {
+	g := &Graph{files: map[string]*FileNode{}}
+	for i, cmd := range commands {
+		c := &CommandNode{ID: i, Command: cmd}
+		for _, output := range cmd.Outputs {
+			f := g.file(output)
+			if f.Producer != nil {
+				return nil, fmt.Errorf("%s is produced by two rules: %s and %s",
+					output, f.Producer.Command.Rule.Pos, cmd.Rule.Pos)
+			}
+			f.Producer = c
+			c.Outputs = append(c.Outputs, f)
+		}
+		g.Commands = append(g.Commands, c)
+	}
+	for _, c := range g.Commands {
+		for _, input := range c.Command.Inputs {
+			f := g.file(input)
+			f.Consumers = append(f.Consumers, c)
+			c.Inputs = append(c.Inputs, f)
+		}
+	}
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Graph) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.Commands))
+	// stack[i] depends on stack[i+1] by the file via[i]
+	stack := []*CommandNode{}
+	via := []string{}
+
+	var visit func(c *CommandNode) error
+	visit = func(c *CommandNode) error {
+		state[c.ID] = visiting
+		stack = append(stack, c)
+		for _, input := range c.Inputs {
+			p := input.Producer
+			if p == nil {
+				continue
+			}
+			switch state[p.ID] {
+			case visiting:
+				k := len(stack) - 1
+				for stack[k] != p {
+					k--
+				}
+				// files in order of building: each one is used to build the next one
+				cycle := []string{input.Path}
+				for i := len(via) - 1; i >= k; i-- {
+					cycle = append(cycle, via[i])
+				}
+				cycle = append(cycle, input.Path)
+				return fmt.Errorf("dependency cycle: %s (%s)", strings.Join(cycle, " -> "), c.Command.Rule.Pos)
+			case unvisited:
+				via = append(via, input.Path)
+				if err := visit(p); err != nil {
+					return err
+				}
+				via = via[:len(via)-1]
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[c.ID] = visited
+		return nil
+	}
+
+	for _, c := range g.Commands {
+		if state[c.ID] == unvisited {
+			if err := visit(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
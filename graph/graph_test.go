@@ -0,0 +1,100 @@
+package graph
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anru/vake/glob"
+	"github.com/anru/vake/vakefile"
+)
+
+var testTree = []string{"src/a.css", "src/b.css", "src/main.js"}
+
+const testVakefile = `
+: foreach src/*.css |> csso %f -o %o |> build/%B.min.css
+: build/*.css |> cat %f > %o |> static/bundle.css
+: src/main.js |> terser %f > %o |> static/app.js
+: static/bundle.css static/app.js |> tar cf %o %f |> dist.tar
+`
+
+func newTestGraph(t *testing.T, source string) (*Graph, error) {
+	nodes, err := vakefile.Parse("graph", source, &vakefile.ParserEnv{}).Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands, err := vakefile.ExpandRules(nodes, glob.NewTree(testTree))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(commands)
+}
+
+func ids(commands []*CommandNode) []int {
+	out := []int{}
+	for _, c := range commands {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+func TestGraph(t *testing.T) {
+	g, err := newTestGraph(t, testVakefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Commands) != 5 {
+		t.Fatalf("expected 5 commands, got %d", len(g.Commands))
+	}
+	if p := g.Producer("build/a.min.css"); p == nil || p.ID != 0 {
+		t.Errorf("unexpected producer of build/a.min.css: %v", p)
+	}
+	if p := g.Producer("src/a.css"); p != nil {
+		t.Errorf("source file should not have producer, got %v", p)
+	}
+	if c := ids(g.Consumers("static/bundle.css")); !reflect.DeepEqual(c, []int{4}) {
+		t.Errorf("unexpected consumers of static/bundle.css: %v", c)
+	}
+	if deps := ids(g.TransitiveDeps(g.Commands[4])); !reflect.DeepEqual(deps, []int{0, 1, 2, 3}) {
+		t.Errorf("unexpected transitive deps: %v", deps)
+	}
+	if deps := ids(g.TransitiveDependents(g.Commands[0])); !reflect.DeepEqual(deps, []int{2, 4}) {
+		t.Errorf("unexpected transitive dependents: %v", deps)
+	}
+	targets, err := g.Targets([]string{"static/bundle.css"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(targets); !reflect.DeepEqual(got, []int{0, 1, 2}) {
+		t.Errorf("unexpected commands of the target: %v", got)
+	}
+	if _, err := g.Targets([]string{"src/a.css"}); err == nil {
+		t.Error("expected error for target without rule")
+	}
+}
+
+func TestSort(t *testing.T) {
+	g, err := newTestGraph(t, ": a.out |> cat %f > %o |> b.out\n: src/main.js |> cp %f %o |> a.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(g.Sort()); !reflect.DeepEqual(got, []int{1, 0}) {
+		t.Errorf("expected producer to go first, got %v", got)
+	}
+}
+
+var graphErrorTestCases = map[string]string{
+	": src/a.css |> cp %f %o |> x.css\n: src/b.css |> cp %f %o |> x.css":                       "x.css is produced by two rules: graph:1 and graph:2",
+	": a.out |> cp %f %o |> b.out\n: b.out |> cp %f %o |> a.out":                               "dependency cycle: b.out -> a.out -> b.out",
+	": a.out |> cp %f %o |> a.out":                                                             "dependency cycle: a.out -> a.out",
+	": c.out |> cp %f %o |> a.out\n: a.out |> cp %f %o |> b.out\n: b.out |> cp %f %o |> c.out": "dependency cycle: a.out -> b.out -> c.out -> a.out",
+}
+
+func TestGraphErrors(t *testing.T) {
+	for source, expected := range graphErrorTestCases {
+		_, err := newTestGraph(t, source)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("[%s] expected error '%s', got '%v'", source, expected, err)
+		}
+	}
+}
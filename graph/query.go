@@ -0,0 +1,140 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+)
+
+// File returns node of the file, nil if the file is not in the graph
+func (g *Graph) File(path string) *FileNode {
+	return g.files[path]
+}
+
+// Files returns all files of the graph sorted by path
+func (g *Graph) Files() []*FileNode {
+	files := make([]*FileNode, 0, len(g.files))
+	for _, f := range g.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files
+}
+
+// Producer returns command producing the file, nil for source files
+func (g *Graph) Producer(path string) *CommandNode {
+	if f := g.files[path]; f != nil {
+		return f.Producer
+	}
+	return nil
+}
+
+// Consumers returns commands which use the file as input
+func (g *Graph) Consumers(path string) []*CommandNode {
+	if f := g.files[path]; f != nil {
+		return f.Consumers
+	}
+	return nil
+}
+
+// walk visits commands reachable by next function
+// in at most depth steps, 0 is unlimited
+func walk(start []*CommandNode, depth int, next func(*CommandNode) []*CommandNode) []*CommandNode {
+	distance := map[*CommandNode]int{}
+	out := []*CommandNode{}
+	queue := append([]*CommandNode{}, start...)
+	for len(queue) != 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if depth != 0 && distance[c] == depth {
+			continue
+		}
+		for _, n := range next(c) {
+			if _, ok := distance[n]; !ok {
+				distance[n] = distance[c] + 1
+				out = append(out, n)
+				queue = append(queue, n)
+			}
+		}
+	}
+	sortByID(out)
+	return out
+}
+
+// dependents returns commands consuming outputs of the command
+func (c *CommandNode) dependents() []*CommandNode {
+	seen := map[*CommandNode]bool{}
+	out := []*CommandNode{}
+	for _, output := range c.Outputs {
+		for _, consumer := range output.Consumers {
+			if !seen[consumer] {
+				seen[consumer] = true
+				out = append(out, consumer)
+			}
+		}
+	}
+	return out
+}
+
+// TransitiveDeps returns all commands the command depends on
+func (g *Graph) TransitiveDeps(c *CommandNode) []*CommandNode {
+	return walk([]*CommandNode{c}, 0, (*CommandNode).Deps)
+}
+
+// TransitiveDependents returns all commands depending on the command
+func (g *Graph) TransitiveDependents(c *CommandNode) []*CommandNode {
+	return walk([]*CommandNode{c}, 0, (*CommandNode).dependents)
+}
+
+// Sort returns commands in topological order,
+// independent commands keep declaration order
+func (g *Graph) Sort() []*CommandNode {
+	return g.sort(g.Commands)
+}
+
+func (g *Graph) sort(commands []*CommandNode) []*CommandNode {
+	include := map[*CommandNode]bool{}
+	for _, c := range commands {
+		include[c] = true
+	}
+	done := map[*CommandNode]bool{}
+	out := []*CommandNode{}
+	var visit func(c *CommandNode)
+	visit = func(c *CommandNode) {
+		if done[c] {
+			return
+		}
+		done[c] = true
+		for _, dep := range c.Deps() {
+			if include[dep] {
+				visit(dep)
+			}
+		}
+		out = append(out, c)
+	}
+	sorted := append([]*CommandNode{}, commands...)
+	sortByID(sorted)
+	for _, c := range sorted {
+		visit(c)
+	}
+	return out
+}
+
+// Targets returns commands needed to build given files in topological order,
+// all commands are needed if there are no targets
+func (g *Graph) Targets(paths []string) ([]*CommandNode, error) {
+	if len(paths) == 0 {
+		return g.Sort(), nil
+	}
+	needed := []*CommandNode{}
+	for _, path := range paths {
+		f := g.files[path]
+		if f == nil || f.Producer == nil {
+			return nil, fmt.Errorf("there is no rule to build %s", path)
+		}
+		needed = append(needed, f.Producer)
+		needed = append(needed, g.TransitiveDeps(f.Producer)...)
+	}
+	return g.sort(needed), nil
+}
@@ -0,0 +1,73 @@
+// Package help renders documentation of vakefile labels
+package help
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anru/vake/vakefile"
+)
+
+// Summary returns the first non empty line of the doc
+func Summary(doc string) string {
+	for _, line := range strings.Split(doc, "\n") {
+		if line = strings.TrimSpace(line); len(line) != 0 {
+			return renderInline(strings.TrimLeft(line, "# "), false)
+		}
+	}
+	return ""
+}
+
+// Usage returns command line which runs the label
+func Usage(label *vakefile.LabelNode) string {
+	parts := []string{"vake run", label.Name}
+	for _, param := range label.Params {
+		switch {
+		case param.Variadic:
+			parts = append(parts, "["+param.Name+"...]")
+		case param.HasDefault:
+			parts = append(parts, fmt.Sprintf("[%s=%q]", param.Name, param.Default))
+		default:
+			parts = append(parts, "<"+param.Name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// List writes all labels with their summaries
+func List(w io.Writer, labels []*vakefile.LabelNode) error {
+	if len(labels) == 0 {
+		_, err := fmt.Fprintln(w, "There are no labels in the vakefile.")
+		return err
+	}
+	var table bytes.Buffer
+	tw := tabwriter.NewWriter(&table, 0, 4, 4, ' ', 0)
+	for _, label := range labels {
+		fmt.Fprintf(tw, "    %s\t%s\n", label.Name, Summary(label.Doc))
+	}
+	tw.Flush()
+	// labels without doc are padded by tabwriter
+	lines := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	_, err := fmt.Fprintf(w, "Available labels:\n%s\n", strings.Join(lines, "\n"))
+	return err
+}
+
+// Describe writes full documentation of the label
+func Describe(w io.Writer, label *vakefile.LabelNode, color bool) error {
+	fmt.Fprintf(w, "Usage: %s\n", Usage(label))
+	if len(label.Deps) != 0 {
+		fmt.Fprintf(w, "Depends on: %s\n", strings.Join(label.Deps, ", "))
+	}
+	fmt.Fprintln(w)
+	if len(label.Doc) == 0 {
+		_, err := fmt.Fprintln(w, "No documentation.")
+		return err
+	}
+	return Render(w, label.Doc, color)
+}
@@ -0,0 +1,52 @@
+package help
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/anru/vake/vakefile"
+)
+
+var renderTestCases = map[string]string{
+	"# Deploy\n\nRuns **deploy** with `rsync`":       "DEPLOY\n\nRuns deploy with rsync\n",
+	"## Options\n- `env` target\n  * nested":         "Options\n  • env target\n    • nested\n",
+	"Example:\n```\nvake run deploy prod\n```\ndone": "Example:\n    vake run deploy prod\ndone\n",
+}
+
+func TestRender(t *testing.T) {
+	for md, expected := range renderTestCases {
+		out := &bytes.Buffer{}
+		if err := Render(out, md, false); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("[%q] expected %q, got %q", md, expected, out.String())
+		}
+	}
+}
+
+func TestListAndDescribe(t *testing.T) {
+	labels := []*vakefile.LabelNode{
+		{
+			Name:   "deploy",
+			Doc:    "Deploys `app`\n\nMore details",
+			Params: []vakefile.LabelParam{{Name: "env"}, {Name: "region", Default: "eu", HasDefault: true}, {Name: "flags", Variadic: true}},
+			Deps:   []string{"build"},
+		},
+		{Name: "lint"},
+	}
+
+	out := &bytes.Buffer{}
+	List(out, labels)
+	expected := "Available labels:\n    deploy    Deploys app\n    lint\n"
+	if out.String() != expected {
+		t.Errorf("expected list %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	Describe(out, labels[0], false)
+	expected = "Usage: vake run deploy <env> [region=\"eu\"] [flags...]\nDepends on: build\n\nDeploys app\n\nMore details\n"
+	if out.String() != expected {
+		t.Errorf("expected description %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,80 @@
+package help
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiCode  = "\x1b[36m"
+	ansiReset = "\x1b[0m"
+)
+
+var (
+	boldRe       = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+	headerRe     = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+)
+
+// renderInline renders bold text and inline code
+func renderInline(line string, color bool) string {
+	line = boldRe.ReplaceAllStringFunc(line, func(m string) string {
+		text := m[2 : len(m)-2]
+		if color {
+			return ansiBold + text + ansiReset
+		}
+		return text
+	})
+	return inlineCodeRe.ReplaceAllStringFunc(line, func(m string) string {
+		text := m[1 : len(m)-1]
+		if color {
+			return ansiCode + text + ansiReset
+		}
+		return text
+	})
+}
+
+// Render writes markdown text for the terminal, color enables ANSI escapes
+func Render(w io.Writer, md string, color bool) error {
+	var b strings.Builder
+	inFence := false
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			b.WriteString("    ")
+			if color {
+				b.WriteString(ansiCode + line + ansiReset)
+			} else {
+				b.WriteString(line)
+			}
+			b.WriteString("\n")
+			continue
+		}
+
+		if m := headerRe.FindStringSubmatch(line); m != nil {
+			header := renderInline(m[2], false)
+			if len(m[1]) == 1 {
+				header = strings.ToUpper(header)
+			}
+			if color {
+				header = ansiBold + header + ansiReset
+			}
+			b.WriteString(header + "\n")
+			continue
+		}
+		if m := bulletRe.FindStringSubmatch(line); m != nil {
+			b.WriteString(m[1] + "  • " + renderInline(m[2], color) + "\n")
+			continue
+		}
+		b.WriteString(renderInline(line, color) + "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// checkDeps looks for undefined labels, dependency cycles and
+// dependencies with required parameters, labels in checked are skipped
+func (r *Runner) checkDeps(name string, path []string, checked map[string]bool) error {
+	if checked[name] {
+		return nil
+	}
+	for i, visited := range path {
+		if visited == name {
+			cycle := append(append([]string{}, path[i:]...), name)
+			return fmt.Errorf("label dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	label, err := r.Label(name)
+	if err == nil && len(path) != 0 {
+		// dependencies are run without arguments
+		_, err = r.bindParams(label, nil)
+	}
+	if err != nil {
+		if len(path) != 0 {
+			return fmt.Errorf("%s (required by %s)", err, path[len(path)-1])
+		}
+		return err
+	}
+	path = append(path, name)
+	for _, dep := range label.Deps {
+		if err := r.checkDeps(dep, path, checked); err != nil {
+			return err
+		}
+	}
+	checked[name] = true
+	return nil
+}
+
+// labelRun is a single run of the label within invocation
+type labelRun struct {
+	once sync.Once
+	err  error
+}
+
+// invocation runs every label at most once
+type invocation struct {
+	runner *Runner
+	slots  chan struct{}
+	// arguments of the invoked label, dependencies are run without them
+	name string
+	args []string
+
+	mu   sync.Mutex
+	runs map[string]*labelRun
+	// serializes output of finished labels
+	outputMu sync.Mutex
+}
+
+// labelOutput keeps output of a label until the label finishes,
+// so output of labels run in parallel is not interleaved
+type labelOutput struct {
+	mu     sync.Mutex
+	chunks []outputChunk
+}
+
+type outputChunk struct {
+	w    io.Writer
+	data []byte
+}
+
+// writer returns writer buffering output for w
+func (o *labelOutput) writer(w io.Writer) io.Writer {
+	return chunkWriter{o, w}
+}
+
+// flush writes buffered output in the order it was written
+func (o *labelOutput) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, chunk := range o.chunks {
+		chunk.w.Write(chunk.data)
+	}
+	o.chunks = nil
+}
+
+type chunkWriter struct {
+	o *labelOutput
+	w io.Writer
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	w.o.mu.Lock()
+	defer w.o.mu.Unlock()
+	w.o.chunks = append(w.o.chunks, outputChunk{w.w, append([]byte{}, p...)})
+	return len(p), nil
+}
+
+func (r *Runner) newInvocation(name string, args []string) *invocation {
+	jobs := r.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &invocation{
+		runner: r,
+		slots:  make(chan struct{}, jobs),
+		runs:   map[string]*labelRun{},
+		name:   name,
+		args:   args,
+	}
+}
+
+func (inv *invocation) labelRun(name string) *labelRun {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	run, ok := inv.runs[name]
+	if !ok {
+		run = &labelRun{}
+		inv.runs[name] = run
+	}
+	return run
+}
+
+func (inv *invocation) run(name string) error {
+	run := inv.labelRun(name)
+	run.once.Do(func() {
+		run.err = inv.runLabel(name)
+	})
+	return run.err
+}
+
+func (inv *invocation) runLabel(name string) error {
+	label, err := inv.runner.Label(name)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(label.Deps))
+	var wg sync.WaitGroup
+	for i, dep := range label.Deps {
+		wg.Add(1)
+		go func(i int, dep string) {
+			defer wg.Done()
+			errs[i] = inv.run(dep)
+		}(i, dep)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	inv.slots <- struct{}{}
+	defer func() { <-inv.slots }()
+
+	// the invoked label runs alone after its dependencies,
+	// so only output of dependencies is buffered
+	if name == inv.name {
+		return inv.runner.runBody(label, inv.args)
+	}
+	out := &labelOutput{}
+	buffered := *inv.runner
+	buffered.Stdout = out.writer(inv.runner.Stdout)
+	buffered.Stderr = out.writer(inv.runner.Stderr)
+	defer func() {
+		inv.outputMu.Lock()
+		defer inv.outputMu.Unlock()
+		out.flush()
+	}()
+	return buffered.runBody(label, nil)
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anru/vake/vakefile"
+)
+
+// scope is a set of variables visible in the code block
+type scope struct {
+	vars map[string]string
+	// environment of commands
+	env []string
+}
+
+// CheckArgs returns an error if the label is not defined
+// or does not accept the arguments
+func (r *Runner) CheckArgs(name string, args []string) error {
+	label, err := r.Label(name)
+	if err != nil {
+		return err
+	}
+	_, err = r.bindParams(label, args)
+	return err
+}
+
+// bindParams assigns arguments to label parameters,
+// parameters are visible as variables and environment variables
+func (r *Runner) bindParams(label *vakefile.LabelNode, args []string) (*scope, error) {
+	sc := &scope{
+		vars: make(map[string]string, len(r.vars)+len(label.Params)),
+		env:  os.Environ(),
+	}
+	for name, value := range r.vars {
+		sc.vars[name] = value
+	}
+
+	rest := args
+	for _, param := range label.Params {
+		var value string
+		switch {
+		case param.Variadic:
+			value = strings.Join(rest, " ")
+			if len(rest) == 0 {
+				value = param.Default
+			}
+			rest = nil
+		case len(rest) != 0:
+			value = rest[0]
+			rest = rest[1:]
+		case param.HasDefault:
+			value = param.Default
+		default:
+			return nil, fmt.Errorf("%s: missing argument for parameter %s", label.Name, param.Name)
+		}
+		sc.vars[param.Name] = value
+		sc.env = append(sc.env, param.Name+"="+value)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%s: expected at most %d arguments, got %d", label.Name, len(label.Params), len(args))
+	}
+
+	return sc, nil
+}
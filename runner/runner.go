@@ -0,0 +1,188 @@
+// Package runner executes code blocks of vakefile labels
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/anru/vake/vakefile"
+)
+
+// DefaultShell runs every line of a code block
+var DefaultShell = []string{"sh", "-c"}
+
+type Runner struct {
+	// Shell is a command line, a code line is passed as the last argument
+	Shell  []string
+	Dir    string
+	Stdout io.Writer
+	Stderr io.Writer
+	// Jobs limits number of labels running at the same time
+	Jobs int
+
+	vars   map[string]string
+	labels map[string]*vakefile.LabelNode
+}
+
+// New creates runner for labels found in parsed nodes
+func New(nodes []vakefile.Node, vars map[string]string) *Runner {
+	r := &Runner{
+		Shell:  DefaultShell,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Jobs:   runtime.NumCPU(),
+		vars:   vars,
+		labels: map[string]*vakefile.LabelNode{},
+	}
+	for _, node := range nodes {
+		if label, ok := node.(*vakefile.LabelNode); ok {
+			r.labels[label.Name] = label
+		}
+	}
+	return r
+}
+
+// Labels returns all labels sorted by name
+func (r *Runner) Labels() []*vakefile.LabelNode {
+	out := make([]*vakefile.LabelNode, 0, len(r.labels))
+	for _, label := range r.labels {
+		out = append(out, label)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Label returns label by name
+func (r *Runner) Label(name string) (*vakefile.LabelNode, error) {
+	label, ok := r.labels[name]
+	if !ok {
+		return nil, fmt.Errorf("label %s is not defined", name)
+	}
+	return label, nil
+}
+
+// Run runs the label with given arguments after all its dependencies,
+// independent dependencies are run in parallel
+func (r *Runner) Run(name string, args ...string) error {
+	if err := r.checkDeps(name, nil, map[string]bool{}); err != nil {
+		return err
+	}
+	// check arguments before running of dependencies
+	if err := r.CheckArgs(name, args); err != nil {
+		return err
+	}
+	return r.newInvocation(name, args).run(name)
+}
+
+// runBody runs code block of the label
+func (r *Runner) runBody(label *vakefile.LabelNode, args []string) error {
+	name := label.Name
+	sc, err := r.bindParams(label, args)
+	if err != nil {
+		return err
+	}
+	if label.Body == nil {
+		return nil
+	}
+	if len(label.Body.Shebang) != 0 {
+		err = r.runScript(name, label.Body.Shebang, label.Body.Code, sc)
+	} else {
+		err = r.runCode(label.Body.Code, sc)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// runScript writes code to a temporary file and runs it by the interpreter
+func (r *Runner) runScript(name, shebang, code string, sc *scope) error {
+	interpreter := strings.Fields(shebang)
+	if len(interpreter) == 0 {
+		return fmt.Errorf("empty interpreter in #! line")
+	}
+
+	f, err := ioutil.TempFile("", "vake-"+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("#!" + shebang + "\n" + expandVars(code, sc.vars) + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := r.command(sc, interpreter[0], append(interpreter[1:], f.Name())...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s script failed: %w", shebang, err)
+	}
+	return nil
+}
+
+func (r *Runner) runCode(code string, sc *scope) error {
+	for _, line := range codeLines(code) {
+		line = expandVars(line, sc.vars)
+		fmt.Fprintln(r.Stderr, line)
+
+		cmd := r.command(sc, r.Shell[0], append(r.Shell[1:], line)...)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("'%s' failed: %w", line, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) command(sc *scope, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = r.Dir
+	cmd.Env = sc.env
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = r.Stderr
+	return cmd
+}
+
+// codeLines splits code to commands, lines ending with \ are continued
+func codeLines(code string) []string {
+	out := []string{}
+	current := ""
+	for _, line := range strings.Split(code, "\n") {
+		if strings.HasSuffix(line, "\\") {
+			current += line + "\n"
+			continue
+		}
+		current += line
+		if len(strings.TrimSpace(current)) != 0 {
+			out = append(out, current)
+		}
+		current = ""
+	}
+	if len(strings.TrimSpace(current)) != 0 {
+		out = append(out, current)
+	}
+	return out
+}
+
+var varRe = regexp.MustCompile(`\$\(([A-Za-z0-9_]+)\)`)
+
+// expandVars substitutes defined $(VAR) references,
+// others are left to shell as command substitutions
+func expandVars(line string, vars map[string]string) string {
+	return varRe.ReplaceAllStringFunc(line, func(ref string) string {
+		if value, ok := vars[ref[2:len(ref)-1]]; ok {
+			return value
+		}
+		return ref
+	})
+}
@@ -0,0 +1,168 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/anru/vake/vakefile"
+)
+
+func newTestRunner(t *testing.T, source string) (*Runner, *bytes.Buffer) {
+	env := vakefile.NewParserEnv("")
+	nodes, err := vakefile.Parse("test", source, env).Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(nodes, env.Vars())
+	out := &bytes.Buffer{}
+	r.Stdout = out
+	r.Stderr = &bytes.Buffer{}
+	return r, out
+}
+
+var runTestCases = map[string]string{
+	"hello:\n  echo hello\n":                           "hello\n",
+	"NAME = vake\nhello:\n  echo $(NAME) $(echo sh)\n": "vake sh\n",
+	"multi:\n  echo a \\\n    b\n  echo c\n":           "a b\nc\n",
+	"lint:\n  echo src \\\n    --fix\n\n  echo done\n": "src --fix\ndone\n",
+	"lines:\n  X=1\n  echo ${X:-unset}\n":              "unset\n",
+	"script:\n  #!/bin/sh -e\n  X=1\n  echo $X\n":      "1\n",
+	"nobody: multi\n\nmulti:\n  echo a\n":              "a\n",
+}
+
+func TestRun(t *testing.T) {
+	for source, expected := range runTestCases {
+		r, out := newTestRunner(t, source)
+		label := strings.SplitN(source, ":", 2)[0]
+		if i := strings.LastIndex(label, "\n"); i != -1 {
+			label = label[i+1:]
+		}
+		if err := r.Run(label); err != nil {
+			t.Errorf("[%q] %v", source, err)
+			continue
+		}
+		if out.String() != expected {
+			t.Errorf("[%q] expected output %q, got %q", source, expected, out.String())
+		}
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	r, out := newTestRunner(t, "fail:\n  echo before\n  false\n  echo after\n")
+	err := r.Run("fail")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if out.String() != "before\n" {
+		t.Errorf("expected to stop after failure, got output %q", out.String())
+	}
+	if _, err := r.Label("unknown"); err == nil {
+		t.Error("expected error for undefined label")
+	}
+}
+
+func TestRunDeps(t *testing.T) {
+	source := `
+all: css js
+  echo all
+
+css: clean
+  echo css
+
+js: clean
+  echo js
+
+clean:
+  echo clean
+`
+	r, out := newTestRunner(t, source)
+	if err := r.Run("all"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "clean" || lines[3] != "all" {
+		t.Errorf("expected clean to run once first and all last, got %q", lines)
+	}
+}
+
+func TestRunDepsOutput(t *testing.T) {
+	source := `
+all: slow fast
+  echo all
+
+slow:
+  echo slow1; sleep 0.2; echo slow2
+
+fast:
+  echo fast1; sleep 0.1; echo fast2
+`
+	r, out := newTestRunner(t, source)
+	r.Jobs = 2
+	if err := r.Run("all"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "slow1\nslow2\n") || !strings.Contains(out.String(), "fast1\nfast2\n") {
+		t.Errorf("expected output of parallel labels not to interleave, got %q", out.String())
+	}
+}
+
+var depsErrorTestCases = map[string]string{
+	"a: b\n  echo a\nb: c\n  echo b\nc: a\n  echo c\n": "label dependency cycle: a -> b -> c -> a",
+	"a: a\n  echo a\n": "label dependency cycle: a -> a",
+	"a: b\n  echo a\n": "label b is not defined (required by a)",
+	"a: b c\n  echo a\nb:\n  false\nc:\n  echo c\n":                                      "b: 'false' failed",
+	"a: b\n  echo a\nb: c\n  echo b\nc x:\n  echo c\n":                                   "c: missing argument for parameter x (required by b)",
+	"a: b c\n  echo a\nb: d\n  echo b\nc: d\n  echo c\nd: e\n  echo d\ne: b\n  echo e\n": "label dependency cycle: b -> d -> e -> b",
+}
+
+func TestRunDepsErrors(t *testing.T) {
+	for source, expected := range depsErrorTestCases {
+		r, out := newTestRunner(t, source)
+		err := r.Run("a")
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("[%q] expected error '%s', got '%v'", source, expected, err)
+		}
+		if strings.Contains(out.String(), "a\n") {
+			t.Errorf("[%q] label should not run after failure", source)
+		}
+	}
+}
+
+var paramsTestCases = map[string][]string{
+	"deploy staging":                   {"staging eu west", "staging\n"},
+	"deploy prod us --force --dry-run": {"prod us --force --dry-run", "prod\n"},
+}
+
+func TestRunParams(t *testing.T) {
+	source := `
+deploy env region="eu west" *flags: check
+  echo $(env) $(region) $(flags)
+  echo $env
+
+check:
+  echo check
+`
+	for cmdline, expected := range paramsTestCases {
+		r, out := newTestRunner(t, source)
+		args := strings.Fields(cmdline)
+		if err := r.Run(args[0], args[1:]...); err != nil {
+			t.Errorf("[%s] %v", cmdline, err)
+			continue
+		}
+		if out.String() != "check\n"+expected[0]+"\n"+expected[1] {
+			t.Errorf("[%s] unexpected output %q", cmdline, out.String())
+		}
+	}
+
+	r, out := newTestRunner(t, source)
+	if err := r.Run("deploy"); err == nil || err.Error() != "deploy: missing argument for parameter env" {
+		t.Errorf("expected missing argument error, got %v", err)
+	}
+	if err := r.Run("check", "extra"); err == nil || err.Error() != "check: expected at most 0 arguments, got 1" {
+		t.Errorf("expected too many arguments error, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("nothing should run on arguments error, got %q", out.String())
+	}
+}
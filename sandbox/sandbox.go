@@ -0,0 +1,24 @@
+// Package sandbox runs commands in isolated Linux namespaces, where only
+// declared inputs of the command are visible and only directories of its
+// outputs are writable
+package sandbox
+
+import "errors"
+
+// ErrUnsupported is returned when namespaces are not available on the platform
+var ErrUnsupported = errors.New("sandbox is not supported on this platform")
+
+// helperEnv marks the process as a sandbox helper, see Main
+const helperEnv = "VAKE_SANDBOX_HELPER"
+
+// Spec describes the sandbox
+type Spec struct {
+	// Root is an absolute path of the project
+	Root string `json:"root"`
+	// Inputs are files of the project visible read only
+	Inputs []string `json:"inputs"`
+	// Writable are directories of the project visible with write access
+	Writable []string `json:"writable"`
+	// Args is the command, the first argument is looked up in PATH
+	Args []string `json:"args"`
+}
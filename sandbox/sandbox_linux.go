@@ -0,0 +1,202 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// specFd is a file descriptor of the helper to read the spec from
+	specFd = 3
+	// oPath is O_PATH, which is missing in syscall
+	oPath = 0x200000
+)
+
+// Command returns the command which runs the sandbox helper. The helper is
+// the current executable in new user, mount and network namespaces,
+// it prepares mounts and replaces itself with the command of the spec.
+// The returned function closes the spec pipe of the parent, it should be
+// called when the command is started or is not going to be started.
+func Command(spec *Spec) (*exec.Cmd, func(), error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the spec could be larger than the pipe buffer, writing fails
+	// when the read end is closed before the helper is started
+	go func() {
+		w.Write(content)
+		w.Close()
+	}()
+
+	cmd := exec.Command(exe)
+	cmd.Dir = spec.Root
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	cmd.ExtraFiles = []*os.File{r}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET,
+		// root of the namespace has capabilities to mount
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	return cmd, func() { r.Close() }, nil
+}
+
+// Check runs an empty command in the sandbox to find out if namespaces are available
+func Check() error {
+	root, err := ioutil.TempDir("", "vake-sandbox")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	cmd, release, err := Command(&Spec{Root: root, Args: []string{"true"}})
+	if err != nil {
+		return err
+	}
+	defer release()
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) != 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// Main runs the helper if the process was started by Command and never
+// returns in that case, it must be called at the start of the main function
+func Main() {
+	if len(os.Getenv(helperEnv)) == 0 {
+		return
+	}
+	os.Unsetenv(helperEnv)
+	err := helper()
+	fmt.Fprintf(os.Stderr, "vake sandbox: %v\n", err)
+	os.Exit(127)
+}
+
+func helper() error {
+	f := os.NewFile(specFd, "spec")
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return err
+	}
+	if len(spec.Args) == 0 {
+		return fmt.Errorf("command is empty")
+	}
+	path, err := exec.LookPath(spec.Args[0])
+	if err != nil {
+		return err
+	}
+	if err := mountProject(spec); err != nil {
+		return err
+	}
+	if err := os.Chdir(spec.Root); err != nil {
+		return err
+	}
+	return syscall.Exec(path, spec.Args, os.Environ())
+}
+
+// mountProject hides the project behind tmpfs and mounts back inputs and
+// writable directories, real files are accessed through the descriptor
+// of the project opened before
+func mountProject(spec *Spec) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("making mounts private: %v", err)
+	}
+	fd, err := syscall.Open(spec.Root, oPath|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	project := "/proc/self/fd/" + strconv.Itoa(fd)
+	// the project is visible when its root is writable
+	hidden := true
+	for _, dir := range spec.Writable {
+		hidden = hidden && dir != "."
+	}
+	// mount points are created through the descriptor of the mounted root,
+	// so tracing of the helper doesn't see them as writes to the project
+	mounted := project
+	if hidden {
+		err := syscall.Mount("tmpfs", spec.Root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=755")
+		if err != nil {
+			return fmt.Errorf("hiding project: %v", err)
+		}
+		tmpfs, err := syscall.Open(spec.Root, oPath|syscall.O_DIRECTORY, 0)
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(tmpfs)
+		mounted = "/proc/self/fd/" + strconv.Itoa(tmpfs)
+	}
+
+	// parents are mounted before their children
+	writable := append([]string{}, spec.Writable...)
+	sort.Slice(writable, func(i, j int) bool {
+		return strings.Count(writable[i], "/") < strings.Count(writable[j], "/")
+	})
+	for _, dir := range writable {
+		if err := os.MkdirAll(filepath.Join(mounted, dir), 0755); err != nil {
+			return err
+		}
+		if err := bind(filepath.Join(project, dir), filepath.Join(spec.Root, dir), false); err != nil {
+			return err
+		}
+	}
+	for _, input := range spec.Inputs {
+		target := filepath.Join(mounted, input)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if f, err := os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644); err == nil {
+			f.Close()
+		} else {
+			return err
+		}
+		if err := bind(filepath.Join(project, input), filepath.Join(spec.Root, input), true); err != nil {
+			return err
+		}
+	}
+	if !hidden {
+		return nil
+	}
+	// nothing else could be created in the project
+	if err := syscall.Mount("", spec.Root, "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("making project read only: %v", err)
+	}
+	return nil
+}
+
+func bind(source, target string, readOnly bool) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("mounting %s: %v", target, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+	if err != nil {
+		return fmt.Errorf("making %s read only: %v", target, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import "os/exec"
+
+// Main does nothing, there is no helper on this platform
+func Main() {}
+
+// Command always fails
+func Command(spec *Spec) (*exec.Cmd, func(), error) {
+	return nil, nil, ErrUnsupported
+}
+
+// Check always fails
+func Check() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,64 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// the test binary is the helper
+	Main()
+	os.Exit(m.Run())
+}
+
+func TestSandbox(t *testing.T) {
+	if err := Check(); err != nil {
+		t.Skip(err)
+	}
+	root, err := ioutil.TempDir("", "vake-sandbox-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	os.MkdirAll(filepath.Join(root, "src"), 0755)
+	os.MkdirAll(filepath.Join(root, "out"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "src", "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+
+	testCases := map[string]bool{
+		"cat src/a.txt > out/a.out":             true,
+		"cat secret.txt":                        false,
+		"echo b >> src/a.txt":                   false,
+		"echo c > new.txt":                      false,
+		"test $(grep -c : /proc/net/dev) -eq 1": true,
+	}
+	for command, ok := range testCases {
+		cmd, release, err := Command(&Spec{
+			Root:     root,
+			Inputs:   []string{"src/a.txt"},
+			Writable: []string{"out"},
+			Args:     []string{"sh", "-c", command},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.CombinedOutput()
+		release()
+		if (err == nil) != ok {
+			t.Errorf("[%s] expected success %v, got %v: %s", command, ok, err, out)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(root, "out", "a.out"))
+	if string(content) != "a" {
+		t.Errorf("expected output in the project, got %q", content)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(root, "src", "a.txt"))
+	if !strings.HasPrefix(string(content), "a") || len(content) != 1 {
+		t.Errorf("input was modified: %q", content)
+	}
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Fingerprint describes content of the file at some moment
+type Fingerprint struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"` // nanoseconds since epoch
+	Inode   uint64 `json:"inode,omitempty"`
+	Hash    string `json:"hash"` // hex encoded sha256 of the content
+}
+
+func metadata(info os.FileInfo) Fingerprint {
+	return Fingerprint{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Inode:   inode(info),
+	}
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Stat fingerprints the file
+func Stat(path string) (Fingerprint, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	f := metadata(info)
+	f.Hash, err = hashFile(path)
+	return f, err
+}
+
+// Check compares the file with the known fingerprint and returns its current one.
+// Content is hashed only when size is the same but mtime or inode differ,
+// so a touched file with the same content is not changed.
+func Check(path string, known Fingerprint) (Fingerprint, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Fingerprint{}, true, err
+	}
+	f := metadata(info)
+	switch {
+	case f.Size != known.Size:
+		f.Hash, err = hashFile(path)
+		return f, true, err
+	case f.ModTime == known.ModTime && f.Inode == known.Inode:
+		f.Hash = known.Hash
+		return f, false, nil
+	}
+	f.Hash, err = hashFile(path)
+	return f, f.Hash != known.Hash, err
+}
+
+// SameContent reports whether both fingerprints describe the same content
+func (f Fingerprint) SameContent(other Fingerprint) bool {
+	return f.Size == other.Size && f.Hash == other.Hash
+}
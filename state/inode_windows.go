@@ -0,0 +1,8 @@
+package state
+
+import "os"
+
+// inode is not available on windows, mtime and size are enough
+func inode(info os.FileInfo) uint64 {
+	return 0
+}
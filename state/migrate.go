@@ -0,0 +1,88 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Version of the state format written by this vake
+const Version = 4
+
+// migration converts raw state of version n to version n+1
+type migration func(raw map[string]json.RawMessage) error
+
+// migrations[n] upgrades state from version n
+var migrations = map[int]migration{
+	1: addFiles,
+	// implicit dependencies are optional, but older vake would lose them
+	2: func(raw map[string]json.RawMessage) error { return nil },
+	3: addVakefile,
+}
+
+// addVakefile guesses vakefile of the records by the file of the rule,
+// outputs of included rules are not removed as stale until they are rebuilt
+func addVakefile(raw map[string]json.RawMessage) error {
+	content, ok := raw["records"]
+	if !ok {
+		return nil
+	}
+	records := []*Record{}
+	if err := json.Unmarshal(content, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if i := strings.LastIndex(r.Rule, ":"); i != -1 {
+			r.Vakefile = r.Rule[:i]
+		}
+	}
+	content, err := json.Marshal(records)
+	raw["records"] = content
+	return err
+}
+
+// addFiles fills files of version 2 with fingerprints of the records,
+// inodes are unknown, so contents of the files will be rehashed once
+func addFiles(raw map[string]json.RawMessage) error {
+	records := []*Record{}
+	if content, ok := raw["records"]; ok {
+		if err := json.Unmarshal(content, &records); err != nil {
+			return err
+		}
+	}
+	files := map[string]Fingerprint{}
+	for _, r := range records {
+		for path, f := range r.Inputs {
+			files[path] = f
+		}
+		for path, f := range r.Outputs {
+			files[path] = f
+		}
+	}
+	content, err := json.Marshal(files)
+	raw["files"] = content
+	return err
+}
+
+func migrate(raw map[string]json.RawMessage) error {
+	version := 0
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return fmt.Errorf("invalid state version: %v", err)
+		}
+	}
+	if version > Version {
+		return fmt.Errorf("state version %d is newer than supported %d, upgrade vake", version, Version)
+	}
+	for ; version < Version; version++ {
+		m, ok := migrations[version]
+		if !ok {
+			return fmt.Errorf("can't migrate state from version %d", version)
+		}
+		if err := m(raw); err != nil {
+			return fmt.Errorf("migration from version %d failed: %v", version, err)
+		}
+	}
+	raw["version"] = json.RawMessage(fmt.Sprint(Version))
+	return nil
+}
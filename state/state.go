@@ -0,0 +1,198 @@
+// Package state stores what vake knows about previous builds
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Dir is a directory of the project where vake keeps its state
+const Dir = ".vake"
+
+// FileName is a name of the state file inside of Dir
+const FileName = "state.json"
+
+// Record describes how outputs were built last time
+type Record struct {
+	Rule    string                 `json:"rule"` // position of the rule
+	Command string                 `json:"command"`
+	Inputs  map[string]Fingerprint `json:"inputs"`
+	Outputs map[string]Fingerprint `json:"outputs"`
+	// Vakefile is the vakefile of the build, it differs from the file
+	// of the rule when the rule is included
+	Vakefile string `json:"vakefile"`
+	// Implicit are undeclared inputs found by tracing of the command
+	Implicit map[string]Fingerprint `json:"implicit,omitempty"`
+	// Dirty is a reason to rebuild outputs even if the command is the same
+	Dirty string `json:"dirty,omitempty"`
+}
+
+// OutputPaths returns sorted outputs of the record
+func (r *Record) OutputPaths() []string {
+	paths := make([]string, 0, len(r.Outputs))
+	for path := range r.Outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+type State struct {
+	Version int       `json:"version"`
+	Records []*Record `json:"records"`
+	// Files are fingerprints of inputs and outputs seen by the last build
+	Files map[string]Fingerprint `json:"files"`
+
+	path     string
+	byOutput map[string]*Record
+}
+
+// New creates empty state stored at path
+func New(path string) *State {
+	return &State{
+		Version:  Version,
+		Files:    map[string]Fingerprint{},
+		path:     path,
+		byOutput: map[string]*Record{},
+	}
+}
+
+// Load reads state of the project at root, missing state is empty
+func Load(root string) (*State, error) {
+	path := filepath.Join(root, Dir, FileName)
+	s := New(path)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := migrate(raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if content, err = json.Marshal(raw); err == nil {
+		err = json.Unmarshal(content, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, r := range s.Records {
+		for output := range r.Outputs {
+			s.byOutput[output] = r
+		}
+	}
+	if s.Files == nil {
+		s.Files = map[string]Fingerprint{}
+	}
+	return s, nil
+}
+
+// Save writes state to the temporary file and renames it,
+// so the state is either old or new after a crash
+func (s *State) Save() error {
+	s.pruneFiles()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, FileName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(append(content, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// Record returns record of the output or nil
+func (s *State) Record(output string) *Record {
+	return s.byOutput[output]
+}
+
+// Set stores the record replacing records of the same outputs
+func (s *State) Set(r *Record) {
+	for output := range r.Outputs {
+		s.Remove(output)
+		s.byOutput[output] = r
+	}
+	s.Records = append(s.Records, r)
+}
+
+// Remove forgets the output, record is dropped with its last output
+func (s *State) Remove(output string) {
+	r := s.byOutput[output]
+	if r == nil {
+		return
+	}
+	delete(s.byOutput, output)
+	delete(r.Outputs, output)
+	if len(r.Outputs) != 0 {
+		return
+	}
+	for i, other := range s.Records {
+		if other == r {
+			s.Records = append(s.Records[:i], s.Records[i+1:]...)
+			break
+		}
+	}
+}
+
+// Outputs returns sorted list of all known outputs
+func (s *State) Outputs() []string {
+	outputs := make([]string, 0, len(s.byOutput))
+	for output := range s.byOutput {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// MarkDirty makes the output to be rebuilt by the next build
+func (s *State) MarkDirty(output, reason string) {
+	if r := s.byOutput[output]; r != nil && len(r.Dirty) == 0 {
+		r.Dirty = reason
+	}
+}
+
+// pruneFiles forgets files which are not referenced by records
+func (s *State) pruneFiles() {
+	used := map[string]bool{}
+	for _, r := range s.Records {
+		for path := range r.Inputs {
+			used[path] = true
+		}
+		for path := range r.Outputs {
+			used[path] = true
+		}
+		for path := range r.Implicit {
+			used[path] = true
+		}
+	}
+	for path := range s.Files {
+		if !used[path] {
+			delete(s.Files, path)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempRoot(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vake-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeState(t *testing.T, root, content string) {
+	os.MkdirAll(filepath.Join(root, Dir), 0755)
+	if err := ioutil.WriteFile(filepath.Join(root, Dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+	path := filepath.Join(root, "a.txt")
+	ioutil.WriteFile(path, []byte("hello"), 0644)
+	f, err := Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Size != 5 || f.Hash != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected fingerprint %+v", f)
+	}
+	if _, err := Stat(filepath.Join(root, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+
+	s, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Outputs()) != 0 {
+		t.Errorf("expected empty state, got %v", s.Outputs())
+	}
+	s.Set(&Record{
+		Rule:    "Vakefile:1",
+		Command: "cat a b > ab && cp ab ab2",
+		Inputs:  map[string]Fingerprint{"a": {Size: 1, ModTime: 10, Hash: "aa"}, "b": {Size: 1, ModTime: 20, Hash: "bb"}},
+		Outputs: map[string]Fingerprint{"ab": {Size: 2, ModTime: 30, Hash: "ab"}, "ab2": {Size: 2, ModTime: 30, Hash: "ab"}},
+	})
+	s.Set(&Record{
+		Rule:    "Vakefile:2",
+		Command: "cp a c",
+		Inputs:  map[string]Fingerprint{"a": {Size: 1, ModTime: 10, Hash: "aa"}},
+		Outputs: map[string]Fingerprint{"c": {Size: 1, ModTime: 40, Hash: "aa"}},
+	})
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Records, s.Records) {
+		t.Errorf("expected records %+v, got %+v", s.Records, loaded.Records)
+	}
+	if r := loaded.Record("ab2"); r == nil || r.Command != "cat a b > ab && cp ab ab2" {
+		t.Errorf("unexpected record of ab2: %+v", r)
+	}
+
+	// a rule now produces only one of the outputs
+	loaded.Set(&Record{
+		Command: "cat a b > ab",
+		Outputs: map[string]Fingerprint{"ab": {Size: 2, ModTime: 50, Hash: "ab"}},
+	})
+	if outputs := loaded.Outputs(); !reflect.DeepEqual(outputs, []string{"ab", "ab2", "c"}) {
+		t.Errorf("unexpected outputs %v", outputs)
+	}
+	if paths := loaded.Record("ab2").OutputPaths(); !reflect.DeepEqual(paths, []string{"ab2"}) {
+		t.Errorf("old record should keep only ab2, got %v", paths)
+	}
+	loaded.Remove("ab2")
+	loaded.Remove("c")
+	if len(loaded.Records) != 1 || loaded.Record("ab").Command != "cat a b > ab" {
+		t.Errorf("unexpected records after removal %+v", loaded.Records)
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Join(root, Dir))
+	if len(files) != 1 {
+		t.Errorf("temporary files are left in the state dir: %v", files)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := map[string]string{
+		"{":                            "unexpected end of JSON input",
+		`{"version": 100}`:             "state version 100 is newer than supported",
+		`{"version": "1"}`:             "invalid state version",
+		`{"version": 1, "records": 1}`: "cannot unmarshal number",
+	}
+	for content, expected := range testCases {
+		root := tempRoot(t)
+		writeState(t, root, content)
+		_, err := Load(root)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("[%s] expected error %q, got %v", content, expected, err)
+		}
+		os.RemoveAll(root)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+	writeState(t, root, `{"outputs": {"a.out": "cp a a.out"}}`)
+
+	_, err := Load(root)
+	if err == nil || !strings.Contains(err.Error(), "can't migrate state from version 0") {
+		t.Errorf("expected migration error, got %v", err)
+	}
+
+	migrations[0] = func(raw map[string]json.RawMessage) error {
+		old := map[string]string{}
+		if err := json.Unmarshal(raw["outputs"], &old); err != nil {
+			return err
+		}
+		records := []*Record{}
+		for output, command := range old {
+			records = append(records, &Record{Command: command, Outputs: map[string]Fingerprint{output: {}}})
+		}
+		delete(raw, "outputs")
+		content, err := json.Marshal(records)
+		raw["records"] = content
+		return err
+	}
+	defer delete(migrations, 0)
+	s, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != Version || s.Record("a.out") == nil || s.Record("a.out").Command != "cp a a.out" {
+		t.Errorf("unexpected migrated state %+v", s)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+	path := filepath.Join(root, "a.txt")
+	ioutil.WriteFile(path, []byte("hello"), 0644)
+	known, _ := Stat(path)
+
+	check := func(name string, expected bool) {
+		current, changed, err := Check(path, known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed != expected {
+			t.Errorf("[%s] expected changed %v, got %v", name, expected, changed)
+		}
+		known = current
+	}
+	check("same file", false)
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(path, later, later)
+	check("touched", false)
+	if known.ModTime != later.UnixNano() {
+		t.Errorf("expected fingerprint with new mtime, got %+v", known)
+	}
+	ioutil.WriteFile(path, []byte("world"), 0644)
+	check("same size", true)
+	ioutil.WriteFile(path, []byte("hello world"), 0644)
+	check("new size", true)
+}
+
+func TestMigrateFiles(t *testing.T) {
+	root := tempRoot(t)
+	defer os.RemoveAll(root)
+	writeState(t, root, `{
+  "version": 1,
+  "records": [{
+    "rule": "Vakefile:1",
+    "command": "cp a b",
+    "inputs": {"a": {"size": 1, "mtime": 10, "hash": "aa"}},
+    "outputs": {"b": {"size": 1, "mtime": 20, "hash": "aa"}}
+  }]
+}`)
+	s, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Fingerprint{
+		"a": {Size: 1, ModTime: 10, Hash: "aa"},
+		"b": {Size: 1, ModTime: 20, Hash: "aa"},
+	}
+	if !reflect.DeepEqual(s.Files, expected) {
+		t.Errorf("expected files %+v, got %+v", expected, s.Files)
+	}
+	if vakefile := s.Record("b").Vakefile; vakefile != "Vakefile" {
+		t.Errorf("expected vakefile of the record to be guessed by the rule, got %q", vakefile)
+	}
+}
@@ -0,0 +1,44 @@
+// Package trace finds out which files are accessed by a command
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+)
+
+// ErrUnsupported is returned by Run when tracing is not possible on this platform
+var ErrUnsupported = errors.New("tracing of file accesses is not supported on this platform")
+
+// Accesses are files accessed by the command and its children,
+// paths are absolute and sorted
+type Accesses struct {
+	Reads   []string
+	Writes  []string // created or modified files, including targets of renames
+	Removes []string // removed files, including sources of renames
+}
+
+// ExitError is returned when the traced command fails
+type ExitError struct {
+	Status syscall.WaitStatus
+}
+
+func (e *ExitError) Error() string {
+	if e.Status.Signaled() {
+		return fmt.Sprintf("signal: %v", e.Status.Signal())
+	}
+	return fmt.Sprintf("exit status %d", e.Status.ExitStatus())
+}
+
+// set of paths
+type set map[string]bool
+
+func (s set) sorted() []string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
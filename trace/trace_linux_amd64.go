@@ -0,0 +1,342 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// Supported reports whether Run can trace commands
+func Supported() bool {
+	return true
+}
+
+const (
+	sysOpen      = 2
+	sysExecve    = 59
+	sysTruncate  = 76
+	sysRename    = 82
+	sysMkdir     = 83
+	sysCreat     = 85
+	sysLink      = 86
+	sysUnlink    = 87
+	sysSymlink   = 88
+	sysOpenat    = 257
+	sysMkdirat   = 258
+	sysUnlinkat  = 263
+	sysRenameat  = 264
+	sysLinkat    = 265
+	sysSymlinkat = 266
+	sysRenameat2 = 316
+	sysExecveat  = 322
+	sysOpenat2   = 437
+
+	atFdcwd = -100
+
+	// wait only for children of the tracing thread, other goroutines
+	// could run commands too
+	waitNoThread = 0x20000000
+	// kill tracees if vake dies
+	ptraceExitKill = 0x100000
+
+	traceOptions = syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEFORK |
+		syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACECLONE |
+		syscall.PTRACE_O_TRACEEXEC | ptraceExitKill
+	syscallStop = syscall.SIGTRAP | 0x80
+)
+
+// access is a file access seen on the syscall entry,
+// it's recorded only if the syscall succeeds
+type access struct {
+	path string
+	kind accessKind
+}
+
+type accessKind int
+
+const (
+	read accessKind = iota
+	write
+	remove
+)
+
+type tracee struct {
+	started   bool
+	inSyscall bool
+	pending   []access
+}
+
+type tracer struct {
+	tracees  map[int]*tracee
+	accesses map[accessKind]set
+}
+
+// Run starts the command with ptrace and waits for it, stdout and stderr
+// of the command are copied through pipes. Command should not be started.
+func Run(cmd *exec.Cmd) (*Accesses, error) {
+	copying, err := pipeOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	err = cmd.Start()
+	copying.closeWriters()
+	if err != nil {
+		copying.Wait()
+		return nil, err
+	}
+	defer cmd.Process.Release()
+
+	t := &tracer{
+		tracees:  map[int]*tracee{},
+		accesses: map[accessKind]set{read: {}, write: {}, remove: {}},
+	}
+	status, err := t.run(cmd.Process.Pid)
+	copying.Wait()
+	if err != nil {
+		return nil, err
+	}
+	accesses := &Accesses{
+		Reads:   t.accesses[read].sorted(),
+		Writes:  t.accesses[write].sorted(),
+		Removes: t.accesses[remove].sorted(),
+	}
+	if !status.Exited() || status.ExitStatus() != 0 {
+		return accesses, &ExitError{status}
+	}
+	return accesses, nil
+}
+
+func (t *tracer) run(pid int) (syscall.WaitStatus, error) {
+	var status, rootStatus syscall.WaitStatus
+	// the child stops on exec
+	if _, err := syscall.Wait4(pid, &status, syscall.WALL|waitNoThread, nil); err != nil {
+		return status, err
+	}
+	if err := syscall.PtraceSetOptions(pid, traceOptions); err != nil {
+		syscall.Kill(pid, syscall.SIGKILL)
+		return status, err
+	}
+	t.tracees[pid] = &tracee{started: true}
+	syscall.PtraceSyscall(pid, 0)
+
+	for {
+		wpid, err := syscall.Wait4(-1, &status, syscall.WALL|waitNoThread, nil)
+		if err == syscall.ECHILD {
+			return rootStatus, nil
+		} else if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return rootStatus, err
+		}
+
+		if status.Exited() || status.Signaled() {
+			if wpid == pid {
+				rootStatus = status
+			}
+			delete(t.tracees, wpid)
+			continue
+		}
+		if !status.Stopped() {
+			continue
+		}
+
+		p := t.tracees[wpid]
+		if p == nil {
+			// new child starts with SIGSTOP
+			p = &tracee{}
+			t.tracees[wpid] = p
+		}
+		signal := 0
+		switch sig := status.StopSignal(); {
+		case sig == syscallStop:
+			t.syscall(wpid, p)
+		case sig == syscall.SIGTRAP:
+			// fork, clone or exec event
+		case sig == syscall.SIGSTOP && !p.started:
+			// delivered to attach new children, don't pass it
+		default:
+			signal = int(sig)
+		}
+		p.started = true
+		syscall.PtraceSyscall(wpid, signal)
+	}
+}
+
+func (t *tracer) syscall(pid int, p *tracee) {
+	// stops alternate between entry and exit even if registers are not read
+	p.inSyscall = !p.inSyscall
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		p.pending = nil
+		return
+	}
+	if !p.inSyscall {
+		// exit of the syscall, successful accesses are recorded
+		if int64(regs.Rax) >= 0 {
+			for _, a := range p.pending {
+				t.accesses[a.kind][a.path] = true
+			}
+		}
+		p.pending = nil
+		return
+	}
+
+	path := func(dirfd int32, addr uint64) string {
+		return resolve(pid, dirfd, readString(pid, uintptr(addr)))
+	}
+	switch regs.Orig_rax {
+	case sysOpen:
+		p.pending = openAccesses(path(atFdcwd, regs.Rdi), regs.Rsi)
+	case sysOpenat:
+		p.pending = openAccesses(path(int32(regs.Rdi), regs.Rsi), regs.Rdx)
+	case sysOpenat2:
+		// flags are the first field of struct open_how
+		p.pending = openAccesses(path(int32(regs.Rdi), regs.Rsi), readWord(pid, uintptr(regs.Rdx)))
+	case sysCreat, sysTruncate, sysMkdir:
+		p.pending = []access{{path(atFdcwd, regs.Rdi), write}}
+	case sysMkdirat:
+		p.pending = []access{{path(int32(regs.Rdi), regs.Rsi), write}}
+	case sysExecve:
+		p.pending = []access{{path(atFdcwd, regs.Rdi), read}}
+	case sysExecveat:
+		p.pending = []access{{path(int32(regs.Rdi), regs.Rsi), read}}
+	case sysLink:
+		p.pending = []access{{path(atFdcwd, regs.Rdi), read}, {path(atFdcwd, regs.Rsi), write}}
+	case sysLinkat:
+		p.pending = []access{{path(int32(regs.Rdi), regs.Rsi), read}, {path(int32(regs.Rdx), regs.R10), write}}
+	case sysSymlink:
+		// target of the symlink is not accessed
+		p.pending = []access{{path(atFdcwd, regs.Rsi), write}}
+	case sysSymlinkat:
+		p.pending = []access{{path(int32(regs.Rsi), regs.Rdx), write}}
+	case sysUnlink:
+		p.pending = []access{{path(atFdcwd, regs.Rdi), remove}}
+	case sysUnlinkat:
+		p.pending = []access{{path(int32(regs.Rdi), regs.Rsi), remove}}
+	case sysRename:
+		p.pending = []access{{path(atFdcwd, regs.Rdi), remove}, {path(atFdcwd, regs.Rsi), write}}
+	case sysRenameat, sysRenameat2:
+		p.pending = []access{{path(int32(regs.Rdi), regs.Rsi), remove}, {path(int32(regs.Rdx), regs.R10), write}}
+	}
+}
+
+func openAccesses(path string, flags uint64) []access {
+	mode := flags & syscall.O_ACCMODE
+	accesses := []access{}
+	if mode == syscall.O_RDONLY || mode == syscall.O_RDWR {
+		accesses = append(accesses, access{path, read})
+	}
+	if mode != syscall.O_RDONLY || flags&(syscall.O_CREAT|syscall.O_TRUNC) != 0 {
+		accesses = append(accesses, access{path, write})
+	}
+	return accesses
+}
+
+// readWord reads 64-bit word from memory of the tracee
+func readWord(pid int, addr uintptr) uint64 {
+	word := make([]byte, 8)
+	if n, err := syscall.PtracePeekData(pid, addr, word); err != nil || n != len(word) {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(word)
+}
+
+// readString reads NUL terminated string from memory of the tracee
+func readString(pid int, addr uintptr) string {
+	var buf bytes.Buffer
+	word := make([]byte, 8)
+	for buf.Len() < syscall.PathMax {
+		n, err := syscall.PtracePeekData(pid, addr, word)
+		if err != nil || n == 0 {
+			break
+		}
+		if i := bytes.IndexByte(word[:n], 0); i >= 0 {
+			buf.Write(word[:i])
+			break
+		}
+		buf.Write(word[:n])
+		addr += uintptr(n)
+	}
+	return buf.String()
+}
+
+// resolve makes path absolute relative to the directory fd of the tracee
+func resolve(pid int, dirfd int32, path string) string {
+	if len(path) == 0 || filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	link := "/proc/" + strconv.Itoa(pid) + "/cwd"
+	if dirfd != atFdcwd {
+		link = "/proc/" + strconv.Itoa(pid) + "/fd/" + strconv.Itoa(int(dirfd))
+	}
+	dir, err := os.Readlink(link)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+type copyGroup struct {
+	done    []chan struct{}
+	writers []*os.File
+}
+
+func (g *copyGroup) Wait() {
+	for _, done := range g.done {
+		<-done
+	}
+}
+
+// pipeOutput replaces writers of the command with pipes, because
+// the command is waited by the tracer and not by exec.Cmd
+func pipeOutput(cmd *exec.Cmd) (*copyGroup, error) {
+	g := &copyGroup{}
+	pipes := map[io.Writer]*os.File{}
+	for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *w == nil {
+			continue
+		}
+		if _, ok := (*w).(*os.File); ok {
+			continue
+		}
+		if pw, ok := pipes[*w]; ok {
+			*w = pw
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		done := make(chan struct{})
+		go func(dst io.Writer) {
+			io.Copy(dst, pr)
+			pr.Close()
+			close(done)
+		}(*w)
+		g.done = append(g.done, done)
+		g.writers = append(g.writers, pw)
+		pipes[*w] = pw
+		*w = pw
+	}
+	return g, nil
+}
+
+// closeWriters closes parent ends of the pipes after start
+func (g *copyGroup) closeWriters() {
+	for _, w := range g.writers {
+		w.Close()
+	}
+}
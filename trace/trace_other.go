@@ -0,0 +1,16 @@
+//go:build !linux || !amd64
+// +build !linux !amd64
+
+package trace
+
+import "os/exec"
+
+// Supported reports whether Run can trace commands
+func Supported() bool {
+	return false
+}
+
+// Run always fails, the command is not started
+func Run(cmd *exec.Cmd) (*Accesses, error) {
+	return nil, ErrUnsupported
+}
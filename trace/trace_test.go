@@ -0,0 +1,68 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRun(t *testing.T) {
+	if !Supported() {
+		t.Skip(ErrUnsupported)
+	}
+	dir, err := ioutil.TempDir("", "vake-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0644)
+
+	out := &bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", "cat a.txt > c.txt && (cd sub 2>/dev/null || cat b.txt) && mv c.txt d.txt && echo done && cat missing.txt")
+	cmd.Dir = dir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	accesses, err := Run(cmd)
+	if exitErr, ok := err.(*ExitError); !ok || exitErr.Status.ExitStatus() != 1 {
+		t.Fatalf("expected exit status 1, got %v", err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), []byte("bdone\n")) {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	for _, read := range []string{"a.txt", "b.txt"} {
+		if !contains(accesses.Reads, path(read)) {
+			t.Errorf("expected read of %s in %v", read, accesses.Reads)
+		}
+	}
+	for _, write := range []string{"c.txt", "d.txt"} {
+		if !contains(accesses.Writes, path(write)) {
+			t.Errorf("expected write of %s in %v", write, accesses.Writes)
+		}
+	}
+	if !contains(accesses.Removes, path("c.txt")) || contains(accesses.Removes, path("d.txt")) {
+		t.Errorf("expected only c.txt to be removed, got %v", accesses.Removes)
+	}
+	for _, missing := range []string{"missing.txt", "d.txt"} {
+		if contains(accesses.Reads, path(missing)) {
+			t.Errorf("unexpected read of %s", missing)
+		}
+	}
+}
@@ -1,9 +1,178 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/anru/vake/sandbox"
+	"github.com/anru/vake/vakefile"
+)
+
+// exit codes
+const (
+	exitOk       = 0
+	exitFailure  = 1 // build or label run failed
+	exitUsage    = 2 // invalid command line
+	exitVakefile = 3 // vakefile can't be read or parsed
 )
 
+const usage = `usage: vake [-C dir] [-f file] [-j jobs] [-k] [-s] [-v] <command> [args...]
+
+commands:
+    build [targets...]       build outputs of the rules
+    run <label> [args...]    run the label after its dependencies
+    help [label]             show labels or documentation of the label
+    graph [options] [files...]
+                             export dependency graph around the files, options:
+                             -format dot|mermaid|json, -collapse foreach rules,
+                             -dirty commands only, -depth n commands from files
+    generate <script>        generate shell script of the build
+    parse                    print parsed vakefile
+    refactor                 check that commands are the same as in the last build
+
+flags:
+    -C dir     change to dir before doing anything
+    -f file    use file as vakefile (default Vakefile)
+    -j jobs    number of parallel jobs (default number of CPUs)
+    -k         keep building commands which don't depend on failed ones
+    -s         run rule commands in a sandbox with declared inputs only (Linux)
+    -v         verbose output
+
+exit codes:
+    0 success, 1 build or run failed, 2 usage error, 3 vakefile error`
+
+// usageError is returned on invalid command line
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, a ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, a...)}
+}
+
+// vakefileError is returned when vakefile can't be read or parsed
+type vakefileError struct {
+	err error
+}
+
+func (e *vakefileError) Error() string {
+	return e.err.Error()
+}
+
+// context is shared by all commands
+type context struct {
+	file      string
+	jobs      int
+	keepGoing bool
+	sandbox   bool
+	verbose   bool
+	stdout    io.Writer
+	stderr    io.Writer
+
+	env   *vakefile.ParserEnv
+	nodes []vakefile.Node
+}
+
+func (c *context) logf(format string, a ...interface{}) {
+	if c.verbose {
+		fmt.Fprintf(c.stderr, "vake: "+format+"\n", a...)
+	}
+}
+
+// load parses the vakefile once
+func (c *context) load() error {
+	if c.env != nil {
+		return nil
+	}
+	env := vakefile.NewParserEnv(".")
+	p, err := vakefile.ParseFile(c.file, env)
+	if err != nil {
+		return &vakefileError{err}
+	}
+	nodes, err := p.Nodes()
+	if err != nil {
+		return &vakefileError{fmt.Errorf("%s: %v", c.file, err)}
+	}
+	c.logf("parsed %s: %d nodes", c.file, len(nodes))
+	c.env = env
+	c.nodes = nodes
+	return nil
+}
+
+func exitCode(err error) int {
+	var usageErr *usageError
+	var vakefileErr *vakefileError
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &vakefileErr):
+		return exitVakefile
+	}
+	return exitFailure
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("vake", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dir := flags.String("C", "", "")
+	c := &context{stdout: stdout, stderr: stderr}
+	flags.StringVar(&c.file, "f", vakefile.DefaultFileName, "")
+	flags.IntVar(&c.jobs, "j", runtime.NumCPU(), "")
+	flags.BoolVar(&c.keepGoing, "k", false, "")
+	flags.BoolVar(&c.sandbox, "s", false, "")
+	flags.BoolVar(&c.verbose, "v", false, "")
+
+	err := flags.Parse(args)
+	switch {
+	case err == flag.ErrHelp:
+		fmt.Fprintln(stdout, usage)
+		return exitOk
+	case err != nil:
+		err = usagef("%v", err)
+	case flags.NArg() == 0:
+		err = usagef("command is required")
+	case c.jobs < 1:
+		err = usagef("-j should be positive, got %d", c.jobs)
+	}
+
+	if err == nil && len(*dir) != 0 {
+		c.logf("entering directory %s", *dir)
+		if chdirErr := os.Chdir(*dir); chdirErr != nil {
+			err = usagef("%v", chdirErr)
+		}
+	}
+
+	if err == nil {
+		name := flags.Arg(0)
+		cmd, ok := commands[name]
+		if !ok {
+			err = usagef("unknown command %s", name)
+		} else {
+			err = cmd(c, flags.Args()[1:])
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "vake: %v\n", err)
+		var usageErr *usageError
+		if errors.As(err, &usageErr) {
+			fmt.Fprintln(stderr, usage)
+		}
+	}
+	return exitCode(err)
+}
+
 func main() {
-	fmt.Println("vake")
+	sandbox.Main()
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
 }
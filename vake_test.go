@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testVakefile = `NAME = vake
+
+# Says hello
+hello who="world":
+  echo hello $(who) from $(NAME)
+
+fail:
+  false
+
+: greeting.txt |> tr a-z A-Z < %f > %o |> loud.txt
+`
+
+type cliTestCase struct {
+	args   string
+	code   int
+	stdout string
+}
+
+var cliTestCases = []cliTestCase{
+	{"", exitUsage, ""},
+	{"unknown", exitUsage, ""},
+	{"-j 0 run hello", exitUsage, ""},
+	{"run", exitUsage, ""},
+	{"run hello", exitOk, "hello world from vake\n"},
+	{"-j 2 run hello you", exitOk, "hello you from vake\n"},
+	{"run fail", exitFailure, ""},
+	{"run hello a b", exitUsage, ""},
+	{"run nope", exitUsage, ""},
+	{"help", exitOk, "Available labels:\n    fail\n    hello    Says hello\n"},
+	{"help nope", exitUsage, ""},
+	{"-f Missing parse", exitVakefile, ""},
+	{"-f Broken parse", exitVakefile, ""},
+	{"parse", exitOk, "NAME = vake\nhello who=\"world\":\n  echo hello $(who) from $(NAME)\nfail:\n  false\n: greeting.txt |> tr a-z A-Z < %f > %o |> loud.txt\n"},
+	{"graph -dirty -format mermaid", exitOk, "flowchart LR\n  c0[\"tr a-z A-Z < greeting.txt > loud.txt\"]\n  f1([\"greeting.txt\"])\n  f2[(\"loud.txt\")]\n  f1 --> c0\n  c0 --> f2\n"},
+	{"build nope.txt", exitUsage, ""},
+	{"-j 2 build loud.txt", exitOk, "tr a-z A-Z < greeting.txt > loud.txt\n"},
+	{"graph -dirty -format mermaid", exitOk, "flowchart LR\n"},
+	{"graph -format svg", exitUsage, ""},
+	{"refactor", exitOk, "no differences with the last build, commands checked: 1\n"},
+	{"generate", exitUsage, ""},
+	{"generate build.sh", exitOk, ""},
+	{"graph nope.txt", exitUsage, ""},
+	{"graph -format mermaid loud.txt", exitOk, "flowchart LR\n  c0[\"tr a-z A-Z < greeting.txt > loud.txt\"]\n  f1([\"greeting.txt\"])\n  f2[(\"loud.txt\")]\n  f1 --> c0\n  c0 --> f2\n"},
+	{"-f Other build", exitOk, "cp greeting.txt copy.txt\n"},
+	{"refactor", exitOk, "no differences with the last build, commands checked: 1\n"},
+}
+
+func TestCLI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vake-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Vakefile"), []byte(testVakefile), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Broken"), []byte(": |>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Other"), []byte(": greeting.txt |> cp %f %o |> copy.txt\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "greeting.txt"), []byte("hi"), 0644)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	for _, tc := range cliTestCases {
+		os.Chdir(wd)
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		args := append([]string{"-C", dir}, strings.Fields(tc.args)...)
+		code := run(args, stdout, stderr)
+		if code != tc.code {
+			t.Errorf("[%s] expected exit code %d, got %d, stderr: %s", tc.args, tc.code, code, stderr)
+		}
+		if len(tc.stdout) != 0 && stdout.String() != tc.stdout {
+			t.Errorf("[%s] expected output %q, got %q", tc.args, tc.stdout, stdout)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package vakefile
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/anru/vake/glob"
+)
+
+// Command is a rule expanded over the working tree
+type Command struct {
+	Rule    *RuleNode
+	Inputs  []string
+	Outputs []string
+	// command line with substituted %-flags
+	Cmd string
+}
+
+func (c *Command) String() string {
+	return c.Cmd
+}
+
+// expandOutputs substitutes %-flags of output names
+func (n *RuleNode) expandOutputs(inputs []Input) ([]string, error) {
+	outputs := []string{}
+	for _, output := range splitWords(n.Output) {
+		for i := 0; len(inputs) != 1 && i+1 < len(output); i++ {
+			if output[i] != '%' {
+				continue
+			}
+			if output[i+1] != '%' {
+				return nil, fmt.Errorf("%s: output %s of non foreach rule requires a single input, got %d", n.Pos, output, len(inputs))
+			}
+			i++
+		}
+		t := template{text: output, inputs: inputs, flags: outputFlags}
+		expanded, err := t.expand()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", n.Pos, err)
+		}
+		outputs = append(outputs, path.Clean(expanded))
+	}
+	return outputs, nil
+}
+
+func (n *RuleNode) command(inputs []Input) (*Command, error) {
+	outputs, err := n.expandOutputs(inputs)
+	if err != nil {
+		return nil, err
+	}
+	t := template{text: n.Command, inputs: inputs, outputs: outputs, quote: true, flags: validFlags}
+	cmd, err := t.expand()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.Pos, err)
+	}
+	c := &Command{Rule: n, Outputs: outputs, Cmd: cmd}
+	for _, input := range inputs {
+		c.Inputs = append(c.Inputs, input.Path)
+	}
+	return c, nil
+}
+
+// Expand expands rule inputs over the tree and substitutes %-flags,
+// foreach rule produces a command per input file
+func (n *RuleNode) Expand(tree *glob.Tree) ([]*Command, error) {
+	matches, err := tree.ExpandMatches(n.Inputs, n.Excludes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", n.Pos, err)
+	}
+	inputs := make([]Input, len(matches))
+	literal := map[string]bool{}
+	for i, m := range matches {
+		inputs[i] = Input{Path: m.Path, Glob: m.Glob}
+		literal[m.Path] = m.Literal
+	}
+
+	commands, err := n.commands(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// outputs of the previous build may be matched by input patterns of the same rule
+	outputs := map[string]bool{}
+	for _, c := range commands {
+		for _, output := range c.Outputs {
+			outputs[output] = true
+		}
+	}
+	filtered := inputs[:0]
+	for _, input := range inputs {
+		if literal[input.Path] || !outputs[input.Path] {
+			filtered = append(filtered, input)
+		}
+	}
+	if len(filtered) != len(inputs) {
+		return n.commands(filtered)
+	}
+	return commands, nil
+}
+
+func (n *RuleNode) commands(inputs []Input) ([]*Command, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+
+	if !n.Foreach {
+		c, err := n.command(inputs)
+		if err != nil {
+			return nil, err
+		}
+		return []*Command{c}, nil
+	}
+
+	commands := []*Command{}
+	for _, input := range inputs {
+		c, err := n.command([]Input{input})
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, c)
+	}
+	return commands, nil
+}
+
+// ExpandRules expands all rules in order of declaration, outputs of rules
+// are visible to globs of the following rules
+func ExpandRules(nodes []Node, tree *glob.Tree) ([]*Command, error) {
+	tree = tree.Clone()
+	commands := []*Command{}
+	for _, node := range nodes {
+		rule, ok := node.(*RuleNode)
+		if !ok {
+			continue
+		}
+		ruleCommands, err := rule.Expand(tree)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range ruleCommands {
+			tree.Add(c.Outputs...)
+		}
+		commands = append(commands, ruleCommands...)
+	}
+	return commands, nil
+}
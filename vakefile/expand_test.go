@@ -0,0 +1,96 @@
+package vakefile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anru/vake/glob"
+)
+
+var testTree = []string{
+	"src/a.css",
+	"src/b.css",
+	"src/lib/my file.js",
+	"src/main.js",
+	"static/a.css",
+}
+
+type commands []Command
+
+var expandTestCases = map[string]commands{
+	": foreach src/*.css |> csso %f -o %o |> static/%b": {
+		{Inputs: []string{"src/a.css"}, Outputs: []string{"static/a.css"}, Cmd: "csso src/a.css -o static/a.css"},
+		{Inputs: []string{"src/b.css"}, Outputs: []string{"static/b.css"}, Cmd: "csso src/b.css -o static/b.css"},
+	},
+	": src/**/*.js |> cat %f > %o |> app.js": {
+		{Inputs: []string{"src/lib/my file.js", "src/main.js"}, Outputs: []string{"app.js"}, Cmd: "cat 'src/lib/my file.js' src/main.js > app.js"},
+	},
+	": foreach src/*.js |> echo %b %B %e %d %g %O 100%% |> %B.min.js": {
+		{Inputs: []string{"src/main.js"}, Outputs: []string{"main.min.js"}, Cmd: "echo main.js main js src main main.min 100%"},
+	},
+	": src/*.css |> cat %f > %o |> bundle.css\n: foreach bundle.css |> gzip -c %f > %o |> %B.gz": {
+		{Inputs: []string{"src/a.css", "src/b.css"}, Outputs: []string{"bundle.css"}, Cmd: "cat src/a.css src/b.css > bundle.css"},
+		{Inputs: []string{"bundle.css"}, Outputs: []string{"bundle.gz"}, Cmd: "gzip -c bundle.css > bundle.gz"},
+	},
+	": foreach src/*.css |> cp %f %o |> static/%b\n: static/*.css |> cat %f > %o |> all.css": {
+		{Inputs: []string{"src/a.css"}, Outputs: []string{"static/a.css"}, Cmd: "cp src/a.css static/a.css"},
+		{Inputs: []string{"src/b.css"}, Outputs: []string{"static/b.css"}, Cmd: "cp src/b.css static/b.css"},
+		{Inputs: []string{"static/a.css", "static/b.css"}, Outputs: []string{"all.css"}, Cmd: "cat static/a.css static/b.css > all.css"},
+	},
+	": foreach static/*.css src/a.css |> cp %f %o |> static/%b": {
+		{Inputs: []string{"src/a.css"}, Outputs: []string{"static/a.css"}, Cmd: "cp src/a.css static/a.css"},
+	},
+	": src/*.txt |> cat %f |> out.txt": {},
+	": foreach src/main.js |> cp %f %o |> \"my app.js\" dist/%b": {
+		{Inputs: []string{"src/main.js"}, Outputs: []string{"my app.js", "dist/main.js"}, Cmd: "cp src/main.js 'my app.js' dist/main.js"},
+	},
+}
+
+var expandErrorTestCases = map[string]string{
+	": src/*.css |> csso %x |> out.css":      "expand:1: unknown flag %x in 'csso %x'",
+	": src/*.css |> csso %f |> %b":           "expand:1: output %b of non foreach rule requires a single input, got 2",
+	": foreach src/*.css |> csso %f |> %o":   "expand:1: flag %o is not allowed in '%o'",
+	": src/*.css |> csso %f -o %O |> a b":    "expand:1: %O requires exactly one output, got 2 in 'csso %f -o %O'",
+	": src/*.css |> csso %f -o %o 100% |> a": "expand:1: unterminated % at the end of 'csso %f -o %o 100%'",
+}
+
+func expandSource(source string) ([]*Command, error) {
+	nodes, err := Parse("expand", source, &ParserEnv{}).Nodes()
+	if err != nil {
+		return nil, err
+	}
+	return ExpandRules(nodes, glob.NewTree(testTree))
+}
+
+func TestExpandRules(t *testing.T) {
+	for source, expected := range expandTestCases {
+		cmds, err := expandSource(source)
+		if err != nil {
+			t.Errorf("[%s] %v", source, err)
+			continue
+		}
+		if len(cmds) != len(expected) {
+			t.Errorf("[%s] expected %d commands, got %d: %v", source, len(expected), len(cmds), cmds)
+			continue
+		}
+		for i, c := range cmds {
+			if c.Rule == nil {
+				t.Errorf("[%s] command %d has no rule", source, i)
+			}
+			got := Command{Inputs: c.Inputs, Outputs: c.Outputs, Cmd: c.Cmd}
+			if !reflect.DeepEqual(got, expected[i]) {
+				t.Errorf("[%s] expected command %d to be %v, got %v", source, i, expected[i], got)
+			}
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	for source, expected := range expandErrorTestCases {
+		_, err := expandSource(source)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("[%s] expected error '%s', got '%v'", source, expected, err)
+		}
+	}
+}
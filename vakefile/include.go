@@ -0,0 +1,142 @@
+package vakefile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultFileName is the name of the main vakefile of the project
+const DefaultFileName = "Vakefile"
+
+// RulesFileName is the name of files pulled by include_rules
+const RulesFileName = "Vakerules"
+
+// IncludeError is an error occurred in the included file
+type IncludeError struct {
+	Err error
+	// include points ("file:line") from the innermost one to the root
+	Chain []string
+}
+
+func (e *IncludeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	for _, point := range e.Chain {
+		b.WriteString("\n\tincluded from ")
+		b.WriteString(point)
+	}
+	return b.String()
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// include parses file in the same environment and node stream
+func (p *Parser) include(path string, t *token) {
+	for i, included := range p.env.includes {
+		if samePath(included, path) {
+			chain := append(append([]string{}, p.env.includes[i:]...), path)
+			p.errorf("Include cycle at L%d: %s", t.line, strings.Join(chain, " -> "))
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		p.errorf("Can't include %s at L%d: %v", path, t.line, err)
+	}
+
+	child := newParser(path, string(content), p.env, p.nodes, p.errc)
+	p.env.includes = append(p.env.includes, path)
+	defer func() {
+		p.env.includes = p.env.includes[:len(p.env.includes)-1]
+		if e := recover(); e != nil {
+			if _, ok := e.(runtime.Error); ok {
+				panic(e)
+			}
+			child.lexer.drain()
+			point := fmt.Sprintf("%s:%d", p.name, t.line)
+			if incErr, ok := e.(*IncludeError); ok {
+				incErr.Chain = append(incErr.Chain, point)
+				panic(incErr)
+			}
+			panic(&IncludeError{
+				Err:   fmt.Errorf("%s: %v", path, e),
+				Chain: []string{point},
+			})
+		}
+	}()
+	child.parse()
+}
+
+// rulesFiles lists rules files from the project root down to dir
+func (p *Parser) rulesFiles(dir string) []string {
+	root := p.env.root
+	if len(root) == 0 {
+		root = filepath.Dir(p.env.includes[0])
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		p.errorf("include_rules: %v", err)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		p.errorf("include_rules: %v", err)
+	}
+	rel, err := filepath.Rel(absRoot, absDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		p.errorf("include_rules: %s is outside of the project root %s", p.name, root)
+	}
+
+	files := []string{}
+	current := root
+	parts := []string{}
+	if rel != "." {
+		parts = strings.Split(rel, string(filepath.Separator))
+	}
+	for i := 0; ; i++ {
+		candidate := filepath.Join(current, RulesFileName)
+		if stat, err := os.Stat(candidate); err == nil && !stat.IsDir() && !samePath(candidate, p.name) {
+			files = append(files, candidate)
+		}
+		if i == len(parts) {
+			break
+		}
+		current = filepath.Join(current, parts[i])
+	}
+	return files
+}
+
+// include path/to/file.ake
+// include_rules
+func parseInclude(p *Parser) parseResult {
+	t := p.next()
+	switch t.typ {
+	case tokenKeywordInclude:
+		words := p.readPaths("include")
+		if len(words) != 1 {
+			p.errorf("include at L%d: expected exactly one file path", t.line)
+		}
+		path := words[0]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(p.name), path)
+		}
+		p.include(path, t)
+	case tokenKeywordIncludeRules:
+		for _, path := range p.rulesFiles(filepath.Dir(p.name)) {
+			p.include(path, t)
+		}
+	default:
+		return p.back()
+	}
+	return parseOk
+}
@@ -55,6 +55,9 @@ const (
 	tokenQuotedString
 	tokenLabel
 	tokenIdentifier
+	tokenCodeBlock
+	tokenParam
+	tokenCaret
 )
 
 var keywords = map[string]tokenType{
@@ -214,6 +217,14 @@ func (l *lexer) drop() {
 }
 
 func (l *lexer) eatIdentifier() string {
+	if !isIdentifierStartRune(l.peek()) {
+		return ""
+	}
+	return l.eatWord()
+}
+
+// eatWord eats identifier runes, it may start with a digit
+func (l *lexer) eatWord() string {
 	start := l.pos
 	for !isBreakIdentifierRune(l.next()) {
 	}
@@ -539,8 +550,17 @@ func lexOneKeywordOf(kws []string) lexerFn {
 	}
 }
 
-// example: "foo/bar" $(foo) src/commin/*.css
+// ^src/*.test.js
+func lexCaret(l *lexer) lexResult {
+	if l.next() != '^' {
+		return l.backup()
+	}
+	return l.emit(tokenCaret)
+}
+
+// example: "foo/bar" $(foo) src/commin/*.css ^src/*.test.js
 var inputPatternLexers = []lexerFn{
+	lexCaret,
 	lexQuotedString,
 	lexVariable,
 	lexPathPattern,
@@ -558,7 +578,7 @@ var commandStopLexers = []lexerFn{
 }
 
 func lexRuleDest(l *lexer) lexResult {
-	return l.lex([]lexerFn{lexPathPattern})
+	return l.lex(inputPatternLexers)
 }
 
 func lexRuleCommand(l *lexer) lexResult {
@@ -639,10 +659,18 @@ func eatSpaces(l *lexer) lexResult {
 	return lexPass
 }
 
+// lexWord is an identifier which may start with a digit, e.g. ifeq ($(N),1)
+func lexWord(l *lexer) lexResult {
+	if len(l.eatWord()) == 0 {
+		return lexPass
+	}
+	return l.emit(tokenIdentifier)
+}
+
 var ifExpLexers = []lexerFn{
 	lexVariable,
 	lexQuotedString,
-	lexIdentifier,
+	lexWord,
 }
 
 func lexIfeq(l *lexer) lexResult {
@@ -678,6 +706,27 @@ func lexIfeq(l *lexer) lexResult {
 	return lexOk
 }
 
+// include path/to/file.ake
+func lexIncludePath(l *lexer) lexResult {
+	if l.lex(inputPatternLexers) != lexOk {
+		return l.errorf("include: expected file path")
+	}
+	l.dropComments()
+
+	return lexOk
+}
+
+func lexIfdef(l *lexer) lexResult {
+	l.eatAnyOf(hSpace)
+	l.drop()
+	if lexIdentifier(l) != lexOk {
+		return l.errorf("ifdef: expected variable name, got '%v'", l.peek())
+	}
+	l.dropComments()
+
+	return lexOk
+}
+
 // =,+=
 // used after top level identifier
 func lexOperators(l *lexer) lexResult {
@@ -697,14 +746,87 @@ func lexOperators(l *lexer) lexResult {
 	return lexPass
 }
 
+func lexNewline(l *lexer) lexResult {
+	if l.next() != '\n' {
+		return l.backup()
+	}
+	l.drop()
+	return lexOk
+}
+
+// $(foo) any text until new line
+var variableValueLexers = []lexerFn{
+	lexVariable,
+}
+
+var variableValueStopLexers = []lexerFn{
+	lexNewline,
+}
+
+// FOO = $(BAR) text
+// FOO += text
+func lexVariableDef(l *lexer) lexResult {
+	l.eatAnyOf(hSpace)
+	l.drop()
+	if lexOperators(l) != lexOk {
+		return l.errorf("Variable declaration: expected '=' or '+=', got '%v'", l.peek())
+	}
+	l.eatAnyOf(hSpace)
+	l.drop()
+	if r := l.peek(); r == '\n' || r == eof {
+		return lexOk
+	}
+	l.lexUntil(variableValueLexers, variableValueStopLexers, tokenString)
+	return lexOk
+}
+
 var labelDepsLexers = []lexerFn{
 	lexIdentifier,
 }
 
+// deploy env region="eu" *flags:
+func lexLabelParams(l *lexer) lexResult {
+	for {
+		l.eatAnyOf(hSpace)
+		l.drop()
+		r := l.next()
+		if r == ':' {
+			l.drop()
+			return lexOk
+		}
+		// variadic parameter takes the rest of arguments
+		if r != '*' {
+			l.backup()
+		}
+		if len(l.eatIdentifier()) == 0 {
+			return l.errorf("Label declaration: expected parameter name or ':', got '%v'", r)
+		}
+		l.emit(tokenParam)
+		l.drop()
+
+		if l.peek() != '=' {
+			continue
+		}
+		l.next()
+		l.emit(tokenAssign)
+		l.drop()
+		if lexQuotedString(l) == lexOk {
+			continue
+		}
+		for r = l.next(); !strings.ContainsRune(anySpace+":", r) && r != eof; r = l.next() {
+		}
+		l.backup()
+		if l.pos == l.start {
+			return l.errorf("Label declaration: expected default value of parameter")
+		}
+		l.emit(tokenString)
+	}
+}
+
 func lexLabelDeps(l *lexer) lexResult {
 	l.lex(labelDepsLexers)
 	r := l.next()
-	if r != '\n' {
+	if r != '\n' && r != eof {
 		return l.errorf("Label declaration: expected new line, got '%v'", r)
 	}
 	l.drop()
@@ -723,28 +845,32 @@ func lexTopLevelIdentifier(l *lexer) lexResult {
 		switch keywordToken {
 		case tokenKeywordIfeq:
 			return lexIfeq(l)
+		case tokenKeywordIfdef, tokenKeywordIfndef:
+			return lexIfdef(l)
+		case tokenKeywordInclude:
+			return lexIncludePath(l)
 		}
 		return lexOk
 	}
 
-	// check if read identifier is label
-	r := l.next()
-	if r == ':' {
-		l.pos--
-		l.emit(tokenLabel)
-		l.pos++
-		l.drop()
-		if lexLabelDeps(l) == lexError {
-			return lexError
-		}
-		return lexBreakLabelBody
+	pos, line := l.readState()
+	l.eatAnyOf(hSpace)
+	if r := l.peek(); r == '=' || r == '+' || r == '\n' || r == eof {
+		// our identifier is variable
+		l.setReadState(pos, line)
+		l.emit(tokenIdentifier)
+		return lexVariableDef(l)
 	}
-	l.backup()
-
-	// otherwise probably our identifier is variable
-	l.emit(tokenIdentifier)
 
-	return lexOk
+	// otherwise it is label
+	l.emitString(tokenLabel, id)
+	if lexLabelParams(l) == lexError {
+		return lexError
+	}
+	if lexLabelDeps(l) == lexError {
+		return lexError
+	}
+	return lexBreakLabelBody
 }
 
 var topLevelLexers = []lexerFn{
@@ -754,8 +880,64 @@ var topLevelLexers = []lexerFn{
 	lexTopLevelIdentifier,
 }
 
+func isBlankLine(line string) bool {
+	return len(strings.Trim(line, anySpace)) == 0
+}
+
+// dedent removes common indentation of non blank lines
+func dedent(lines []string) string {
+	indent := -1
+	for _, line := range lines {
+		if isBlankLine(line) {
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, hSpace))
+		if indent == -1 || lineIndent < indent {
+			indent = lineIndent
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if !isBlankLine(line) {
+			out[i] = strings.TrimRight(line[indent:], anySpace)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// stateCodeBlock reads indented lines after label declaration
 func stateCodeBlock(l *lexer) stateFn {
-	// TODO
+	// return to the beginning of the line, we've read two spaces already
+	l.setReadState(l.pos-2, l.line)
+	l.drop()
+	lines := []string{}
+	for {
+		start := l.pos
+		r := l.peek()
+		// block lasts until the first not indented line
+		if r == eof || !strings.ContainsRune(anySpace, r) {
+			break
+		}
+		for r = l.next(); r != '\n' && r != eof; r = l.next() {
+		}
+		lines = append(lines, l.input[start:l.pos])
+	}
+	for len(lines) > 0 && isBlankLine(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	code := dedent(lines)
+	// #!/usr/bin/env python3
+	if strings.HasPrefix(code, "#!") {
+		shebang := code
+		code = ""
+		if i := strings.IndexByte(shebang, '\n'); i != -1 {
+			shebang, code = shebang[:i], shebang[i+1:]
+		}
+		l.emitString(tokenShebang, strings.TrimSpace(shebang[2:]))
+	}
+	l.emitString(tokenCodeBlock, code)
+	l.drop()
+
 	return stateInitial
 }
 
@@ -766,6 +948,11 @@ func stateLabelBody(l *lexer) stateFn {
 		// ok, it was label for rules
 		return stateInitial
 	}
+	if r == '\n' || r == eof {
+		l.backup()
+		// label without body, it has only dependencies
+		return stateInitial
+	}
 	// for code blocks I want at least two spaces
 	if r == ' ' {
 		afterSpace := l.next()
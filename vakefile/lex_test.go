@@ -44,6 +44,74 @@ var fileTestCases = map[string]tokens{
 }
 
 var testCases = map[string]tokens{
+	"1abc = x": []token{
+		token{val: "Unparsed statements 1", typ: tokenError},
+	},
+	"ifeq ($(N),1)\nendif": []token{
+		token{val: "ifeq", typ: tokenKeywordIfeq},
+		token{val: "N", typ: tokenVariable},
+		token{val: ",", typ: tokenComma},
+		token{val: "1", typ: tokenIdentifier},
+		token{val: "endif", typ: tokenKeywordEndif},
+	},
+	"a1_b = 2": []token{
+		token{val: "a1_b", typ: tokenIdentifier},
+		token{val: "=", typ: tokenAssign},
+		token{val: "2", typ: tokenString},
+	},
+	": src/*.js ^src/*.test.js |> a |> b": []token{
+		token{val: ":", typ: tokenColon},
+		token{val: "src/*.js", typ: tokenPathPattern},
+		token{val: "^", typ: tokenCaret},
+		token{val: "src/*.test.js", typ: tokenPathPattern},
+		token{val: "|>", typ: tokenPipe},
+		token{val: "a", typ: tokenString},
+		token{val: "|>", typ: tokenPipe},
+		token{val: "b", typ: tokenPathPattern},
+	},
+	": src/**/[a-z]?.{js,jsx} |> cat %f > %o |> app.js": []token{
+		token{val: ":", typ: tokenColon},
+		token{val: "src/**/[a-z]?.{js,jsx}", typ: tokenPathPattern},
+		token{val: "|>", typ: tokenPipe},
+		token{val: "cat %f > %o", typ: tokenString},
+		token{val: "|>", typ: tokenPipe},
+		token{val: "app.js", typ: tokenPathPattern},
+	},
+	"deploy env region=\"eu\" mode=fast *flags: build\n  echo\n": []token{
+		token{val: "deploy", typ: tokenLabel},
+		token{val: "env", typ: tokenParam},
+		token{val: "region", typ: tokenParam},
+		token{val: "=", typ: tokenAssign},
+		token{val: "\"eu\"", typ: tokenQuotedString},
+		token{val: "mode", typ: tokenParam},
+		token{val: "=", typ: tokenAssign},
+		token{val: "fast", typ: tokenString},
+		token{val: "*flags", typ: tokenParam},
+		token{val: "build", typ: tokenIdentifier},
+		token{val: "echo", typ: tokenCodeBlock},
+	},
+	"prep:\n  #!/usr/bin/env python3\n  print(1)\n": []token{
+		token{val: "prep", typ: tokenLabel},
+		token{val: "/usr/bin/env python3", typ: tokenShebang},
+		token{val: "print(1)", typ: tokenCodeBlock},
+	},
+	"build: js\n  echo a\n\n    echo b\n\nFOO = x": []token{
+		token{val: "build", typ: tokenLabel},
+		token{val: "js", typ: tokenIdentifier},
+		token{val: "echo a\n\n  echo b", typ: tokenCodeBlock},
+		token{val: "FOO", typ: tokenIdentifier},
+		token{val: "=", typ: tokenAssign},
+		token{val: "x", typ: tokenString},
+	},
+	"FOO = $(BAR)/x y\nFOO += z": []token{
+		token{val: "FOO", typ: tokenIdentifier},
+		token{val: "=", typ: tokenAssign},
+		token{val: "BAR", typ: tokenVariable},
+		token{val: "/x y", typ: tokenString},
+		token{val: "FOO", typ: tokenIdentifier},
+		token{val: "+=", typ: tokenPlusAssign},
+		token{val: "z", typ: tokenString},
+	},
 	"!bundle_css = foreach": []token{
 		token{val: "bundle_css", typ: tokenMacro},
 		token{val: "=", typ: tokenAssign},
@@ -1,5 +1,10 @@
 package vakefile
 
+import (
+	"fmt"
+	"strings"
+)
+
 type NodeType int
 
 type Node interface {
@@ -14,23 +19,144 @@ const (
 	NodeMacro
 	NodeVariable
 	NodeCodeBlock
+	// ex: [lint: js\n  eslint src]
+	NodeLabel
 )
 
+// Position of the node in the vakefile
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
 type RuleNode struct {
+	Pos     Position
+	Doc     string // comment right above the rule
 	Foreach bool
 	Inputs  []string
-	Command string
-	Output  string
+	// ex: [^src/*.test.js], files excluded from inputs
+	Excludes []string
+	Command  string
+	Output   string
 }
 
 func (n *RuleNode) Type() NodeType {
 	return NodeRule
 }
 
+func ruleString(foreach bool, inputs, excludes []string, command, output string) string {
+	head := strings.Join(inputs, " ")
+	for _, exclude := range excludes {
+		head += " ^" + exclude
+	}
+	if foreach {
+		head = strings.TrimSpace("foreach " + head)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s |> %s |> %s", head, command, output))
+}
+
+func (n *RuleNode) String() string {
+	return ": " + ruleString(n.Foreach, n.Inputs, n.Excludes, n.Command, n.Output)
+}
+
 type MacroNode struct {
-	Name string
+	Name     string
+	Foreach  bool
+	Inputs   []string
+	Excludes []string
+	Command  string
+	Output   string
 }
 
 func (n *MacroNode) Type() NodeType {
 	return NodeMacro
 }
+
+func (n *MacroNode) String() string {
+	return fmt.Sprintf("!%s = %s", n.Name, ruleString(n.Foreach, n.Inputs, n.Excludes, n.Command, n.Output))
+}
+
+// ex: [CFLAGS += -O2 $(DEBUG_FLAGS)]
+type VariableNode struct {
+	Name   string
+	Value  string // expanded value of the right side
+	Append bool
+}
+
+func (n *VariableNode) Type() NodeType {
+	return NodeVariable
+}
+
+func (n *VariableNode) String() string {
+	op := "="
+	if n.Append {
+		op = "+="
+	}
+	return fmt.Sprintf("%s %s %s", n.Name, op, n.Value)
+}
+
+// indented body of the label, common indentation is stripped
+type CodeBlockNode struct {
+	// interpreter from the #! line, code runs line by line in shell if empty
+	Shebang string
+	Code    string
+}
+
+func (n *CodeBlockNode) Type() NodeType {
+	return NodeCodeBlock
+}
+
+func (n *CodeBlockNode) String() string {
+	code := n.Code
+	if len(n.Shebang) != 0 {
+		code = "#!" + n.Shebang + "\n" + code
+	}
+	return "  " + strings.Replace(code, "\n", "\n  ", -1)
+}
+
+// ex: [deploy env region="eu" *flags:]
+type LabelParam struct {
+	Name       string
+	Default    string
+	HasDefault bool
+	// variadic parameter takes the rest of arguments
+	Variadic bool
+}
+
+type LabelNode struct {
+	Doc    string // markdown comment right above the label
+	Name   string
+	Params []LabelParam
+	Deps   []string
+	Body   *CodeBlockNode
+}
+
+func (n *LabelNode) Type() NodeType {
+	return NodeLabel
+}
+
+func (n *LabelNode) String() string {
+	head := []string{n.Name}
+	for _, param := range n.Params {
+		switch {
+		case param.Variadic:
+			head = append(head, "*"+param.Name)
+		case param.HasDefault:
+			head = append(head, fmt.Sprintf("%s=%q", param.Name, param.Default))
+		default:
+			head = append(head, param.Name)
+		}
+	}
+	s := strings.Join(head, " ") + ":"
+	if len(n.Deps) != 0 {
+		s += " " + strings.Join(n.Deps, " ")
+	}
+	if n.Body != nil {
+		s += "\n" + n.Body.String()
+	}
+	return s
+}
@@ -2,7 +2,10 @@ package vakefile
 
 import (
 	"fmt"
+	"io/ioutil"
 	"runtime"
+	"strconv"
+	"strings"
 )
 
 type parseResult int
@@ -16,8 +19,15 @@ const (
 const maxBufSize = 3
 
 type ParserEnv struct {
-	macros map[string]RuleNode
-	vars   map[string]string
+	root     string // project root, include_rules walks from it
+	includes []string
+	macros   map[string]*MacroNode
+	vars     map[string]string
+}
+
+// NewParserEnv creates environment for the project located at root
+func NewParserEnv(root string) *ParserEnv {
+	return &ParserEnv{root: root}
 }
 
 func (e *ParserEnv) hasMacro(name string) bool {
@@ -25,6 +35,46 @@ func (e *ParserEnv) hasMacro(name string) bool {
 	return ok
 }
 
+// Vars returns copy of defined variables
+func (e *ParserEnv) Vars() map[string]string {
+	vars := make(map[string]string, len(e.vars))
+	for name, value := range e.vars {
+		vars[name] = value
+	}
+	return vars
+}
+
+func (e *ParserEnv) setVar(name, value string) {
+	if e.vars == nil {
+		e.vars = map[string]string{}
+	}
+	e.vars[name] = value
+}
+
+func (e *ParserEnv) appendVar(name, value string) {
+	if prev, ok := e.vars[name]; ok && len(prev) != 0 {
+		if len(value) == 0 {
+			return
+		}
+		value = prev + " " + value
+	}
+	e.setVar(name, value)
+}
+
+func (e *ParserEnv) setMacro(n *MacroNode) {
+	if e.macros == nil {
+		e.macros = map[string]*MacroNode{}
+	}
+	e.macros[n.Name] = n
+}
+
+// condFrame is an opened ifeq/ifdef/ifndef block
+type condFrame struct {
+	keyword string
+	line    int
+	hasElse bool
+}
+
 type Parser struct {
 	// input name and lexer
 	name  string
@@ -38,6 +88,9 @@ type Parser struct {
 	ringNext    int // next cell of the ring
 	ringCurrent int // offset of bufHead
 	ringLock    int // value we want to protect and keep ability to return to
+	conds       []condFrame
+	doc         []string // last comment lines
+	docLine     int      // line of the last comment
 
 	// output stream
 	nodes chan Node
@@ -116,10 +169,34 @@ func (p *Parser) recover() {
 
 func (p *Parser) run() {
 	defer p.recover()
+	p.env.includes = append(p.env.includes, p.name)
+	defer func() {
+		p.env.includes = p.env.includes[:len(p.env.includes)-1]
+	}()
+	p.parse()
+	close(p.nodes)
+}
+
+func (p *Parser) parse() {
 	for state := parseStateInitial; state != nil; {
 		state = state(p)
 	}
-	close(p.nodes)
+}
+
+// Nodes reads all parsed nodes, stops on the first error
+func (p *Parser) Nodes() ([]Node, error) {
+	out := []Node{}
+	for {
+		select {
+		case node, ok := <-p.nodes:
+			if !ok {
+				return out, nil
+			}
+			out = append(out, node)
+		case err := <-p.errc:
+			return out, err
+		}
+	}
 }
 
 func (p *Parser) errorf(format string, a ...interface{}) {
@@ -139,6 +216,9 @@ func (p *Parser) readTokensWhile(f func(*token) bool) []string {
 
 func (p *Parser) expect(typ tokenType) *token {
 	t := p.next()
+	if t.typ == tokenError && typ != tokenError {
+		p.lexError(t)
+	}
 	if t.typ != typ {
 		p.errorf("expected token type %v got %v", typ, t)
 	}
@@ -146,6 +226,120 @@ func (p *Parser) expect(typ tokenType) *token {
 	return t
 }
 
+func (p *Parser) lookupVar(name string) string {
+	value, hasVariable := p.env.vars[name]
+	if !hasVariable {
+		p.errorf("Variable %s is not defined", name)
+	}
+	return value
+}
+
+// tokenStart and tokenEnd return bounds of the token source text
+func tokenStart(t *token) Pos {
+	if t.typ == tokenVariable || t.typ == tokenAtVariable {
+		// $(
+		return t.pos - 2
+	}
+	return t.pos
+}
+
+func tokenEnd(t *token) Pos {
+	if t.typ == tokenVariable || t.typ == tokenAtVariable {
+		// )
+		return t.pos + Pos(len(t.val)) + 1
+	}
+	return t.pos + Pos(len(t.val))
+}
+
+// readWords reads path patterns, quoted strings and variables
+// glueing adjacent ones: src/$(DIR)/*.js is a single word,
+// variables with spaces are split to several words,
+// words prefixed with ^ are exclusions
+func (p *Parser) readWords() (words []string, excludes []string) {
+	words = []string{}
+	var word strings.Builder
+	hasWord := false
+	exclude := false
+	flush := func() {
+		if hasWord {
+			if exclude {
+				excludes = append(excludes, word.String())
+			} else {
+				words = append(words, word.String())
+			}
+		}
+		word.Reset()
+		hasWord = false
+	}
+
+	var prev *token
+	for {
+		t := p.next()
+		if t.typ != tokenPathPattern && t.typ != tokenQuotedString && t.typ != tokenVariable && t.typ != tokenCaret {
+			p.back()
+			break
+		}
+		if prev != nil && tokenEnd(prev) != tokenStart(t) {
+			if prev.typ == tokenCaret {
+				p.errorf("Expected pattern right after ^ at L%d", prev.line)
+			}
+			flush()
+			exclude = false
+		}
+		prev = t
+
+		switch t.typ {
+		case tokenCaret:
+			if hasWord {
+				p.errorf("Unexpected ^ in the middle of %s at L%d", word.String(), t.line)
+			}
+			exclude = true
+		case tokenPathPattern:
+			word.WriteString(t.val)
+			hasWord = true
+		case tokenQuotedString:
+			unquoted, err := strconv.Unquote(t.val)
+			if err != nil {
+				p.errorf("Invalid string %s at L%d: %v", t.val, t.line, err)
+			}
+			word.WriteString(unquoted)
+			hasWord = true
+		case tokenVariable:
+			value := p.lookupVar(t.val)
+			fields := strings.Fields(value)
+			for i, field := range fields {
+				if i > 0 || (len(value) > 0 && strings.ContainsRune(anySpace, rune(value[0]))) {
+					flush()
+				}
+				word.WriteString(field)
+				hasWord = true
+			}
+			if len(value) > 0 && strings.ContainsRune(anySpace, rune(value[len(value)-1])) {
+				flush()
+			}
+		}
+	}
+	if prev != nil && prev.typ == tokenCaret {
+		p.errorf("Expected pattern right after ^ at L%d", prev.line)
+	}
+	flush()
+
+	return words, excludes
+}
+
+// readPaths reads words where exclusions are not allowed
+func (p *Parser) readPaths(what string) []string {
+	words, excludes := p.readWords()
+	if len(excludes) != 0 {
+		p.errorf("Exclusion ^%s is not allowed in %s", excludes[0], what)
+	}
+	return words
+}
+
+func (p *Parser) lexError(t *token) {
+	p.errorf("Lexer error: %v, L%d:%d", t.val, t.line, t.pos)
+}
+
 func (p *Parser) parseBy(parsers []parseFn) parseResult {
 	overallParseRes := parsePass
 
@@ -173,29 +367,19 @@ out:
 	return overallParseRes
 }
 
-// :src/*.js |> !bundle_js |> app/bundle.js
-// :foreach src/*.js |> !bundle_js |> app/%b
-func parseRule(p *Parser) parseResult {
-	t := p.next()
-	if t.typ != tokenColon {
-		return p.back()
-	}
-	// ok, create node now
-	n := RuleNode{}
-	t = p.peek()
-
-	if t.typ == tokenKeywordForeach {
+// [foreach] src/*.js $(foo)
+func (p *Parser) parseRuleInputs(n *RuleNode) {
+	if p.peek().typ == tokenKeywordForeach {
+		p.next()
 		n.Foreach = true
 	}
 
-	n.Inputs = p.readTokensWhile(func(t *token) bool {
-		return t.typ == tokenPathPattern
-	})
-
-	if len(n.Inputs) == 0 {
-		p.errorf("empty input for rule")
-	}
+	n.Inputs, n.Excludes = p.readWords()
+}
 
+// |> cat %f > %o |>
+// |> !bundle_js |>
+func (p *Parser) parseRuleCommand(n *RuleNode) {
 	p.expect(tokenPipe)
 
 	// now we expect (tokenMacro | [tokenVariable, tokenQuotedString, tokenString]+)
@@ -203,24 +387,27 @@ func parseRule(p *Parser) parseResult {
 	var atLeastOneTokenForCommandEaten bool = false
 CommandLoop:
 	for {
-		t = p.next()
+		t := p.next()
 
 		switch t.typ {
 		case tokenMacro:
-			macroRule, hasMacro := p.env.macros[t.val]
+			if atLeastOneTokenForCommandEaten {
+				p.errorf("Macro %s should be the only command of the rule", t.val)
+			}
+			macro, hasMacro := p.env.macros[t.val]
 			if !hasMacro {
 				p.errorf("Macro %s is not defined", t.val)
 			}
 
-			n.Command = macroRule.Command
-			n.Output = macroRule.Output
+			n.Foreach = n.Foreach || macro.Foreach
+			n.Inputs = append(n.Inputs, macro.Inputs...)
+			n.Excludes = append(n.Excludes, macro.Excludes...)
+			n.Command = macro.Command
+			n.Output = macro.Output
+			p.expect(tokenPipe)
 			break CommandLoop
 		case tokenVariable:
-			variableValue, hasVariable := p.env.vars[t.val]
-			if !hasVariable {
-				p.errorf("Variable %s is not defined", t.val)
-			}
-			n.Command += variableValue
+			n.Command += p.lookupVar(t.val)
 		case tokenString:
 			n.Command += t.val
 		case tokenQuotedString:
@@ -231,44 +418,406 @@ CommandLoop:
 			}
 			break CommandLoop
 		case tokenError:
-			p.errorf("Lexer error: %v, L%d:%d", t.val, t.line, t.pos)
+			p.lexError(t)
 		default:
 			p.errorf("Unexpected token %s", t.val)
 		}
 		atLeastOneTokenForCommandEaten = true
 	}
+}
 
-	t = p.expect(tokenPathPattern)
+// |> app/bundle.js
+func (p *Parser) parseRuleOutput(n *RuleNode) {
+	words := p.readPaths("rule output")
+	if len(words) == 0 {
+		return
+	}
 	if len(n.Output) != 0 {
 		n.Output += " "
 	}
-	n.Output += t.val
+	n.Output += joinWords(words)
+}
+
+// :src/*.js |> !bundle_js |> app/bundle.js
+// :foreach src/*.js |> !bundle_js |> app/%b
+func parseRule(p *Parser) parseResult {
+	t := p.next()
+	if t.typ != tokenColon {
+		return p.back()
+	}
+	// ok, create node now
+	n := RuleNode{
+		Pos: Position{File: p.name, Line: t.line},
+		Doc: p.takeDoc(t.line),
+	}
+
+	p.parseRuleInputs(&n)
+	if len(n.Inputs) == 0 {
+		p.errorf("empty input for rule")
+	}
+	p.parseRuleCommand(&n)
+	p.parseRuleOutput(&n)
+	if len(n.Output) == 0 {
+		p.errorf("empty output for rule")
+	}
 
 	p.nodes <- &n
-	return parsePass
+	return parseOk
 }
 
-var topLevelParsers = []parseFn{
-	parseRule,
+// !bundle_js = |> cat %f | node_modules/.bin/terser -c > %o |>
+// !bundle_css = foreach src/common.css |> cat %f > %o |> %B.css
+func parseMacro(p *Parser) parseResult {
+	t := p.next()
+	if t.typ != tokenMacro {
+		return p.back()
+	}
+	p.expect(tokenAssign)
+
+	body := RuleNode{}
+	p.parseRuleInputs(&body)
+	p.parseRuleCommand(&body)
+	p.parseRuleOutput(&body)
+
+	n := MacroNode{
+		Name:     t.val,
+		Foreach:  body.Foreach,
+		Inputs:   body.Inputs,
+		Excludes: body.Excludes,
+		Command:  body.Command,
+		Output:   body.Output,
+	}
+	p.env.setMacro(&n)
+
+	p.nodes <- &n
+	return parseOk
+}
+
+// FOO = $(BAR) text
+// FOO += text
+func parseVariable(p *Parser) parseResult {
+	t := p.next()
+	if t.typ != tokenIdentifier {
+		return p.back()
+	}
+	n := VariableNode{Name: t.val}
+
+	op := p.next()
+	switch op.typ {
+	case tokenAssign:
+	case tokenPlusAssign:
+		n.Append = true
+	case tokenError:
+		p.lexError(op)
+	default:
+		p.errorf("Variable %s: expected '=' or '+=', got %v", n.Name, op)
+	}
+
+	var value strings.Builder
+ValueLoop:
+	for {
+		t = p.next()
+		switch t.typ {
+		case tokenString:
+			value.WriteString(t.val)
+		case tokenVariable:
+			value.WriteString(p.lookupVar(t.val))
+		default:
+			p.back()
+			break ValueLoop
+		}
+	}
+	n.Value = strings.TrimSpace(value.String())
+
+	if n.Append {
+		p.env.appendVar(n.Name, n.Value)
+	} else {
+		p.env.setVar(n.Name, n.Value)
+	}
+
+	p.nodes <- &n
+	return parseOk
+}
+
+// ($(NODE_ENV),development)
+func (p *Parser) parseIfeqCondition(ifTok *token) bool {
+	readArg := func() string {
+		var arg strings.Builder
+		for {
+			t := p.next()
+			if t.line != ifTok.line {
+				p.back()
+				break
+			}
+			switch t.typ {
+			case tokenVariable:
+				// undefined variables are empty in conditions
+				arg.WriteString(p.env.vars[t.val])
+			case tokenQuotedString:
+				unquoted, err := strconv.Unquote(t.val)
+				if err != nil {
+					p.errorf("Invalid string %s at L%d: %v", t.val, t.line, err)
+				}
+				arg.WriteString(unquoted)
+			case tokenIdentifier:
+				arg.WriteString(t.val)
+			case tokenError:
+				p.lexError(t)
+			default:
+				p.back()
+				return arg.String()
+			}
+		}
+		return arg.String()
+	}
+
+	left := readArg()
+	p.expect(tokenComma)
+	right := readArg()
+
+	return left == right
+}
+
+// skipBranch skips tokens of false branch until the matching else or endif
+func (p *Parser) skipBranch(frame *condFrame) tokenType {
+	depth := 0
+	for {
+		t := p.next()
+		switch t.typ {
+		case tokenKeywordIfeq, tokenKeywordIfdef, tokenKeywordIfndef:
+			depth++
+		case tokenKeywordElse:
+			if depth == 0 {
+				if frame.hasElse {
+					p.errorf("Duplicate else for %s at L%d", frame.keyword, frame.line)
+				}
+				return t.typ
+			}
+		case tokenKeywordEndif:
+			if depth == 0 {
+				return t.typ
+			}
+			depth--
+		case tokenEOF:
+			p.errorf("Missing endif for %s at L%d", frame.keyword, frame.line)
+		case tokenError:
+			p.lexError(t)
+		}
+	}
+}
+
+// ifeq ($(NODE_ENV),development)
+// ifdef FOO
+// ifndef FOO
+// else
+// endif
+func parseConditional(p *Parser) parseResult {
+	t := p.next()
+	var cond bool
+	switch t.typ {
+	case tokenKeywordIfeq:
+		cond = p.parseIfeqCondition(t)
+	case tokenKeywordIfdef, tokenKeywordIfndef:
+		name := p.expect(tokenIdentifier).val
+		_, cond = p.env.vars[name]
+		if t.typ == tokenKeywordIfndef {
+			cond = !cond
+		}
+	case tokenKeywordElse:
+		if len(p.conds) == 0 {
+			p.errorf("Unbalanced else at L%d: there is no opened ifeq/ifdef/ifndef", t.line)
+		}
+		frame := &p.conds[len(p.conds)-1]
+		if frame.hasElse {
+			p.errorf("Duplicate else for %s at L%d", frame.keyword, frame.line)
+		}
+		frame.hasElse = true
+		// we were in the true branch, so else branch should be skipped
+		p.skipBranch(frame)
+		p.conds = p.conds[:len(p.conds)-1]
+		return parseOk
+	case tokenKeywordEndif:
+		if len(p.conds) == 0 {
+			p.errorf("Unbalanced endif at L%d: there is no opened ifeq/ifdef/ifndef", t.line)
+		}
+		p.conds = p.conds[:len(p.conds)-1]
+		return parseOk
+	default:
+		return p.back()
+	}
+
+	p.conds = append(p.conds, condFrame{keyword: t.val, line: t.line})
+	if !cond {
+		frame := &p.conds[len(p.conds)-1]
+		if p.skipBranch(frame) == tokenKeywordElse {
+			frame.hasElse = true
+		} else {
+			p.conds = p.conds[:len(p.conds)-1]
+		}
+	}
+	return parseOk
+}
+
+// env region="eu" *flags
+func (p *Parser) parseLabelParams(n *LabelNode) {
+	for {
+		t := p.next()
+		if t.typ != tokenParam {
+			p.back()
+			return
+		}
+		param := LabelParam{Name: t.val}
+		if strings.HasPrefix(param.Name, "*") {
+			param.Name = param.Name[1:]
+			param.Variadic = true
+		}
+		if len(n.Params) != 0 {
+			last := n.Params[len(n.Params)-1]
+			if last.Variadic {
+				p.errorf("Label %s at L%d: variadic parameter %s should be the last one", n.Name, t.line, last.Name)
+			}
+			if last.HasDefault && !param.Variadic && p.peek().typ != tokenAssign {
+				p.errorf("Label %s at L%d: parameter %s without default value follows parameter with default", n.Name, t.line, param.Name)
+			}
+		}
+
+		if p.peek().typ == tokenAssign {
+			p.next()
+			value := p.next()
+			switch value.typ {
+			case tokenQuotedString:
+				unquoted, err := strconv.Unquote(value.val)
+				if err != nil {
+					p.errorf("Invalid string %s at L%d: %v", value.val, value.line, err)
+				}
+				param.Default = unquoted
+			case tokenString:
+				param.Default = value.val
+			case tokenError:
+				p.lexError(value)
+			default:
+				p.errorf("Label %s at L%d: expected default value of %s", n.Name, t.line, param.Name)
+			}
+			param.HasDefault = true
+		}
+		n.Params = append(n.Params, param)
+	}
+}
+
+// lint: js
+// <indented code block>
+func parseLabel(p *Parser) parseResult {
+	t := p.next()
+	if t.typ != tokenLabel {
+		return p.back()
+	}
+	n := LabelNode{Name: t.val, Deps: []string{}, Doc: p.takeDoc(t.line)}
+	p.parseLabelParams(&n)
+	for {
+		dep := p.next()
+		if dep.typ != tokenIdentifier || dep.line != t.line {
+			p.back()
+			break
+		}
+		n.Deps = append(n.Deps, dep.val)
+	}
+
+	body := p.next()
+	switch body.typ {
+	case tokenShebang:
+		n.Body = &CodeBlockNode{Shebang: body.val}
+		n.Body.Code = p.expect(tokenCodeBlock).val
+	case tokenCodeBlock:
+		n.Body = &CodeBlockNode{Code: body.val}
+	default:
+		p.back()
+	}
+
+	p.nodes <- &n
+	return parseOk
+}
+
+// # doc comment line
+// comments directly above labels and rules are attached to them
+func parseComment(p *Parser) parseResult {
+	t := p.next()
+	if t.typ != tokenComment {
+		return p.back()
+	}
+	if t.line != p.docLine+1 {
+		p.doc = p.doc[:0]
+	}
+	p.doc = append(p.doc, t.val)
+	p.docLine = t.line
+	return parseOk
+}
+
+// takeDoc returns comment lines placed right before the line
+func (p *Parser) takeDoc(line int) string {
+	if len(p.doc) == 0 || p.docLine != line-1 {
+		return ""
+	}
+	doc := strings.Join(p.doc, "\n")
+	p.doc = p.doc[:0]
+	return doc
+}
+
+// topLevelParsers is a function, because included files are parsed
+// by the same parsers, a variable would be initialized in a cycle
+func topLevelParsers() []parseFn {
+	return []parseFn{
+		parseComment,
+		parseConditional,
+		parseInclude,
+		parseVariable,
+		parseMacro,
+		parseRule,
+		parseLabel,
+	}
 }
 
 func parseStateInitial(p *Parser) parserStateFn {
-	p.parseBy(topLevelParsers)
+	p.parseBy(topLevelParsers())
+
+	t := p.next()
+	switch t.typ {
+	case tokenEOF:
+		if len(p.conds) != 0 {
+			frame := p.conds[len(p.conds)-1]
+			p.errorf("Missing endif for %s at L%d", frame.keyword, frame.line)
+		}
+	case tokenError:
+		p.lexError(t)
+	default:
+		p.errorf("Unexpected token %v at L%d", t, t.line)
+	}
 	return nil
 }
 
-func Parse(name, input string, env *ParserEnv) *Parser {
-	p := &Parser{
+func newParser(name, input string, env *ParserEnv, nodes chan Node, errc chan error) *Parser {
+	return &Parser{
 		env:      env,
 		name:     name,
-		nodes:    make(chan Node),
-		errc:     make(chan error, 1),
+		lexer:    lex(name, input),
+		nodes:    nodes,
+		errc:     errc,
 		ringLock: -1,
 		ringNext: 1,
 	}
+}
 
-	p.lexer = lex(name, input)
+func Parse(name, input string, env *ParserEnv) *Parser {
+	p := newParser(name, input, env, make(chan Node), make(chan error, 1))
 	go p.run()
 
 	return p
 }
+
+// ParseFile parses vakefile at given path, includes are resolved relative to it
+func ParseFile(path string, env *ParserEnv) (*Parser, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, string(content), env), nil
+}
@@ -3,6 +3,7 @@ package vakefile
 import (
 	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -11,6 +12,7 @@ type nodes []Node
 var parserTestCases = map[string]nodes{
 	"simplest-rule": nodes{
 		&RuleNode{
+			Pos: Position{"simplest-rule", 1},
 			Inputs: []string{
 				"src/*.js",
 			},
@@ -18,6 +20,138 @@ var parserTestCases = map[string]nodes{
 			Output:  "app.js",
 		},
 	},
+	"docs": nodes{
+		&RuleNode{
+			Pos:     Position{"docs", 2},
+			Doc:     "Bundles javascript",
+			Inputs:  []string{"src/*.js"},
+			Command: "cat %f > %o",
+			Output:  "app.js",
+		},
+		&LabelNode{
+			Doc:    "Deploys the application\n\nUsage: `vake run deploy staging`",
+			Name:   "deploy",
+			Params: []LabelParam{{Name: "env"}},
+			Deps:   []string{},
+			Body:   &CodeBlockNode{Code: "echo $(env)"},
+		},
+	},
+	"excludes": nodes{
+		&VariableNode{Name: "TESTS", Value: "src/*.test.js src/*.spec.js"},
+		&MacroNode{
+			Name:     "bundle_js",
+			Inputs:   []string{"src/vendor/*.js"},
+			Excludes: []string{"src/vendor/*.min.js"},
+			Command:  "cat %f > %o",
+		},
+		&RuleNode{
+			Pos:      Position{"excludes", 4},
+			Inputs:   []string{"src/*.js", "src/vendor/*.js"},
+			Excludes: []string{"src/*.test.js", "src/*.spec.js", "src/skip me.js", "src/vendor/*.min.js"},
+			Command:  "cat %f > %o",
+			Output:   "app.js",
+		},
+	},
+	"macro": nodes{
+		&MacroNode{
+			Name:    "bundle_js",
+			Inputs:  []string{},
+			Command: "cat %f | node_modules/.bin/terser > %o",
+		},
+		&MacroNode{
+			Name:    "bundle_css",
+			Foreach: true,
+			Inputs:  []string{"src/common.css"},
+			Command: "cat %f > %o",
+			Output:  "%B.css",
+		},
+		&RuleNode{
+			Pos:     Position{"macro", 4},
+			Inputs:  []string{"src/*.js"},
+			Command: "cat %f | node_modules/.bin/terser > %o",
+			Output:  "app.js",
+		},
+		&RuleNode{
+			Pos:     Position{"macro", 5},
+			Foreach: true,
+			Inputs:  []string{"src/*.css", "src/common.css"},
+			Command: "cat %f > %o",
+			Output:  "%B.css",
+		},
+	},
+	"variables": nodes{
+		&VariableNode{Name: "SRC", Value: "src"},
+		&VariableNode{Name: "JS", Value: "src/a.js src/b.js"},
+		&VariableNode{Name: "JS", Value: "src/c.js", Append: true},
+		&VariableNode{Name: "TERSER", Value: "node_modules/.bin/terser"},
+		&VariableNode{Name: "OUT_DIR", Value: "dist2"},
+		&RuleNode{
+			Pos:     Position{"variables", 7},
+			Inputs:  []string{"src/a.js", "src/b.js", "src/c.js", "src/d e.js"},
+			Command: "cat %f | node_modules/.bin/terser > %o",
+			Output:  "dist2/app.js",
+		},
+	},
+	"conditionals": nodes{
+		&VariableNode{Name: "NODE_ENV", Value: "development"},
+		&VariableNode{Name: "MODE", Value: "dev"},
+		&VariableNode{Name: "MODE", Value: "full", Append: true},
+		&MacroNode{
+			Name:    "bundle_js",
+			Inputs:  []string{},
+			Command: "cat %f > %o",
+		},
+	},
+	"code-block": nodes{
+		&VariableNode{Name: "LINT", Value: "eslint"},
+		&LabelNode{
+			Name: "lint",
+			Deps: []string{"js"},
+			Body: &CodeBlockNode{Code: "$(LINT) src \\\n  --fix\n\necho done"},
+		},
+		&LabelNode{
+			Name: "js",
+			Deps: []string{},
+			Body: &CodeBlockNode{Code: "echo js"},
+		},
+		&RuleNode{
+			Pos:     Position{"code-block", 11},
+			Inputs:  []string{"src/*.js"},
+			Command: "cat %f > %o",
+			Output:  "app.js",
+		},
+		&LabelNode{Name: "all", Deps: []string{"lint", "js"}},
+		&LabelNode{
+			Name: "prep",
+			Deps: []string{},
+			Body: &CodeBlockNode{
+				Shebang: "/usr/bin/env python3",
+				Code:    "import sys\n\nprint(sys.argv)",
+			},
+		},
+		&LabelNode{
+			Name: "deploy",
+			Params: []LabelParam{
+				{Name: "env"},
+				{Name: "region", Default: "eu west", HasDefault: true},
+				{Name: "flags", Variadic: true},
+			},
+			Deps: []string{"lint"},
+			Body: &CodeBlockNode{Code: "echo $(env) $(region) $(flags)"},
+		},
+	},
+}
+
+var parserErrorTestCases = map[string]string{
+	": a ^ b |> c |> d":            "Expected pattern right after ^ at L1",
+	": a b^c |> c |> d":            "Unexpected ^ in the middle of b at L1",
+	": a |> c |> d ^e":             "Exclusion ^e is not allowed in rule output",
+	"a *b c:\n  echo":              "Label a at L1: variadic parameter b should be the last one",
+	"a b=1 c:\n  echo":             "Label a at L1: parameter c without default value follows parameter with default",
+	"endif":                        "Unbalanced endif at L1",
+	"ifdef FOO\nelse\nelse\nendif": "Duplicate else for ifdef at L1",
+	"ifeq (a,b)\n: a |> b |> c":    "Missing endif for ifeq at L1",
+	"ifndef FOO\nelse\n":           "Missing endif for ifndef at L1",
 }
 
 func doParserTest(t *testing.T, filename string, env *ParserEnv) {
@@ -62,9 +196,78 @@ MainLoop:
 	}
 }
 
+func TestParserErrors(t *testing.T) {
+	for source, expected := range parserErrorTestCases {
+		env := ParserEnv{}
+		p := Parse(source, source, &env)
+	MainLoop:
+		for {
+			select {
+			case _, ok := <-p.nodes:
+				if !ok {
+					t.Errorf("[%s] expected error '%s', got none", source, expected)
+					break MainLoop
+				}
+			case err := <-p.errc:
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("[%s] expected error '%s', got '%v'", source, expected, err)
+				}
+				break MainLoop
+			}
+		}
+	}
+}
+
 func TestParser(t *testing.T) {
-	env := ParserEnv{}
 	for filename := range parserTestCases {
+		env := ParserEnv{}
 		doParserTest(t, filename, &env)
 	}
 }
+
+func TestInclude(t *testing.T) {
+	env := NewParserEnv("_test-files/include")
+	p, err := ParseFile("_test-files/include/sub/Vakefile", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvNodes, err := p.Nodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := nodes{
+		&VariableNode{Name: "ROOT_VAR", Value: "root"},
+		&MacroNode{Name: "bundle_js", Inputs: []string{}, Command: "cat %f > %o"},
+		&VariableNode{Name: "ROOT_VAR", Value: "sub", Append: true},
+		&VariableNode{Name: "COMMON", Value: "yes"},
+		&RuleNode{
+			Pos:     Position{"_test-files/include/sub/Vakefile", 4},
+			Inputs:  []string{"*.js"},
+			Command: "cat %f > %o",
+			Output:  "app.js",
+		},
+	}
+	if !reflect.DeepEqual(nodes(recvNodes), expected) {
+		t.Errorf("expected: %v, got: %v", expected, recvNodes)
+	}
+	if env.vars["ROOT_VAR"] != "root sub" {
+		t.Errorf("expected ROOT_VAR to be 'root sub', got '%s'", env.vars["ROOT_VAR"])
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	p, err := ParseFile("_test-files/include/cycle-a.ake", NewParserEnv(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Nodes()
+	if err == nil {
+		t.Fatal("expected include cycle error")
+	}
+	expected := "_test-files/include/cycle-b.ake: Include cycle at L2: " +
+		"_test-files/include/cycle-a.ake -> _test-files/include/cycle-b.ake -> _test-files/include/cycle-a.ake\n" +
+		"\tincluded from _test-files/include/cycle-a.ake:1"
+	if err.Error() != expected {
+		t.Errorf("expected error:\n%s\ngot:\n%v", expected, err)
+	}
+}
@@ -0,0 +1,130 @@
+package vakefile
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Input is an expanded input file of the rule
+type Input struct {
+	Path string
+	// text matched by the first wildcard of the input pattern, %g
+	Glob string
+}
+
+var safeShellRe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]+$`)
+
+// ShellQuote quotes string for POSIX shell if needed
+func ShellQuote(s string) string {
+	if safeShellRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func baseNoExt(p string) string {
+	base := path.Base(p)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// flags allowed in output names
+const outputFlags = "bBedg%"
+
+// template is a command or output with %-flags
+//
+//	%f  input files
+//	%o  output files
+//	%O  output file without extension
+//	%b  base names of input files
+//	%B  base names of input files without extension
+//	%e  extensions of input files
+//	%d  directories of input files
+//	%g  text matched by the first wildcard of input patterns
+//	%%  percent sign
+type template struct {
+	text    string
+	inputs  []Input
+	outputs []string
+	// quote paths for shell
+	quote bool
+	// allowed flags
+	flags string
+}
+
+func (t *template) join(values []string) string {
+	if t.quote {
+		quoted := make([]string, len(values))
+		for i, value := range values {
+			quoted[i] = ShellQuote(value)
+		}
+		values = quoted
+	}
+	return strings.Join(values, " ")
+}
+
+func (t *template) mapInputs(f func(Input) string) string {
+	values := make([]string, len(t.inputs))
+	for i, input := range t.inputs {
+		values[i] = f(input)
+	}
+	return t.join(values)
+}
+
+func (t *template) flag(r byte) (string, error) {
+	switch r {
+	case '%':
+		return "%", nil
+	case 'f':
+		return t.mapInputs(func(in Input) string { return in.Path }), nil
+	case 'o':
+		return t.join(t.outputs), nil
+	case 'O':
+		if len(t.outputs) != 1 {
+			return "", fmt.Errorf("%%O requires exactly one output, got %d", len(t.outputs))
+		}
+		out := t.outputs[0]
+		return t.join([]string{strings.TrimSuffix(out, path.Ext(out))}), nil
+	case 'b':
+		return t.mapInputs(func(in Input) string { return path.Base(in.Path) }), nil
+	case 'B':
+		return t.mapInputs(func(in Input) string { return baseNoExt(in.Path) }), nil
+	case 'e':
+		return t.mapInputs(func(in Input) string { return strings.TrimPrefix(path.Ext(in.Path), ".") }), nil
+	case 'd':
+		return t.mapInputs(func(in Input) string { return path.Dir(in.Path) }), nil
+	case 'g':
+		return t.mapInputs(func(in Input) string { return in.Glob }), nil
+	}
+	return "", fmt.Errorf("unknown flag %%%c", r)
+}
+
+// expand substitutes all %-flags of the template
+func (t *template) expand() (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(t.text); i++ {
+		c := t.text[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 == len(t.text) {
+			return "", fmt.Errorf("unterminated %% at the end of '%s'", t.text)
+		}
+		i++
+		r := rune(t.text[i])
+		if !isValidFlag(r) {
+			return "", fmt.Errorf("unknown flag %%%c in '%s'", r, t.text)
+		}
+		if !strings.ContainsRune(t.flags, r) {
+			return "", fmt.Errorf("flag %%%c is not allowed in '%s'", r, t.text)
+		}
+		value, err := t.flag(t.text[i])
+		if err != nil {
+			return "", fmt.Errorf("%v in '%s'", err, t.text)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), nil
+}
@@ -2,23 +2,74 @@ package vakefile
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 )
 
-func isBreakIdentifierRune(r rune) bool {
+// isIdentifierStartRune reports whether identifier can start with the rune,
+// digits are allowed after the first rune only
+func isIdentifierStartRune(r rune) bool {
 	switch {
 	case r >= 'a' && r <= 'z':
-		return false
+		return true
 	case r >= 'A' && r <= 'Z':
-		return false
+		return true
 	case r == '_':
-		return false
+		return true
+	}
+	return false
+}
+
+func isBreakIdentifierRune(r rune) bool {
+	return !isIdentifierStartRune(r) && !(r >= '0' && r <= '9')
+}
+
+// joinWords joins words by spaces, words with spaces or quotes are quoted
+func joinWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = word
+		if len(word) == 0 || strings.ContainsAny(word, anySpace+`"`) {
+			quoted[i] = strconv.Quote(word)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitWords splits text joined by joinWords back to words
+func splitWords(text string) []string {
+	words := []string{}
+	for {
+		text = strings.TrimLeft(text, anySpace)
+		if len(text) == 0 {
+			return words
+		}
+		end := strings.IndexAny(text, anySpace)
+		if end == -1 {
+			end = len(text)
+		}
+		if text[0] == '"' {
+			for i := 1; i < len(text); i++ {
+				if text[i] == '\\' {
+					i++
+				} else if text[i] == '"' {
+					end = i + 1
+					break
+				}
+			}
+			if word, err := strconv.Unquote(text[:end]); err == nil {
+				words = append(words, word)
+				text = text[end:]
+				continue
+			}
+		}
+		words = append(words, text[:end])
+		text = text[end:]
 	}
-	return true
 }
 
-const allowedPatternChars = "/*%.:-"
+const allowedPatternChars = "/*%.:-?[]{},!\\"
 
 func isBreakPatternRune(r rune) bool {
 	switch {